@@ -0,0 +1,287 @@
+package pmtiles
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/protomaps/go-pmtiles/pmtiles"
+)
+
+// archiveMetadata is the subset of the (JSON) metadata for a PMTiles archive used to configure and validate a
+// `PMTilesSpatialDatabase` instance.
+type archiveMetadata struct {
+	VectorLayers []*archiveVectorLayer `json:"vector_layers"`
+}
+
+// archiveVectorLayer is an element of the "vector_layers" property in the metadata for a PMTiles archive.
+type archiveVectorLayer struct {
+	Id      string `json:"id"`
+	MinZoom *int   `json:"minzoom,omitempty"`
+	MaxZoom *int   `json:"maxzoom,omitempty"`
+}
+
+// readArchiveMetadata returns the metadata for the PMTiles archive 'name' in 'bucket'.
+func readArchiveMetadata(ctx context.Context, bucket pmtiles.Bucket, name string) (*archiveMetadata, error) {
+
+	key := fmt.Sprintf("%s.pmtiles", name)
+
+	body, _, err := readArchiveHeader(ctx, bucket, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := pmtiles.DeserializeHeader(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to deserialize header for %s, %w", key, err)
+	}
+
+	return readArchiveMetadataWithHeader(ctx, bucket, key, header)
+}
+
+// readArchiveMetadataWithHeader returns the metadata for the PMTiles archive 'key' in 'bucket' whose header is
+// 'header'. Metadata is read from the bucket directly, rather than using a `pmtiles.Server` instance, so that
+// archives can be validated before the server is started.
+func readArchiveMetadataWithHeader(ctx context.Context, bucket pmtiles.Bucket, key string, header pmtiles.HeaderV3) (*archiveMetadata, error) {
+
+	r, err := bucket.NewRangeReader(ctx, key, int64(header.MetadataOffset), int64(header.MetadataLength))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read metadata for %s, %w", key, err)
+	}
+
+	defer r.Close()
+
+	body, err := pmtiles.DeserializeMetadataBytes(r, header.InternalCompression)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read metadata for %s, %w", key, err)
+	}
+
+	var md *archiveMetadata
+
+	err = json.Unmarshal(body, &md)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal metadata for %s, %w", key, err)
+	}
+
+	return md, nil
+}
+
+// vectorLayer returns the vector layer named 'name' and a boolean value indicating whether it was found.
+func (md *archiveMetadata) vectorLayer(name string) (*archiveVectorLayer, bool) {
+
+	for _, l := range md.VectorLayers {
+
+		if l.Id == name {
+			return l, true
+		}
+	}
+
+	return nil, false
+}
+
+// vectorLayerIds returns the sorted list of vector layer names.
+func (md *archiveMetadata) vectorLayerIds() []string {
+
+	ids := make([]string, len(md.VectorLayers))
+
+	for i, l := range md.VectorLayers {
+		ids[i] = l.Id
+	}
+
+	slices.Sort(ids)
+	return ids
+}
+
+// defaultLayer returns the name of the layer to read features from when one has not been specified. If the archive
+// has a layer with the same name as the archive ('name') that layer is used, otherwise the archive must contain
+// exactly one layer.
+func (md *archiveMetadata) defaultLayer(name string) (string, error) {
+
+	if len(md.VectorLayers) == 0 {
+		return name, nil
+	}
+
+	_, exists := md.vectorLayer(name)
+
+	if exists {
+		return name, nil
+	}
+
+	if len(md.VectorLayers) == 1 {
+		return md.VectorLayers[0].Id, nil
+	}
+
+	return "", fmt.Errorf("Unable to determine default layer for %s, please specify one of: %s", name, strings.Join(md.vectorLayerIds(), ", "))
+}
+
+// validateLayer ensures that 'layer' exists in the archive. If the archive metadata does not define any vector
+// layers there is nothing to validate.
+func (md *archiveMetadata) validateLayer(layer string) error {
+
+	if len(md.VectorLayers) == 0 {
+		return nil
+	}
+
+	_, exists := md.vectorLayer(layer)
+
+	if !exists {
+		return fmt.Errorf("Invalid ?layer= parameter, %s is not one of: %s", layer, strings.Join(md.vectorLayerIds(), ", "))
+	}
+
+	return nil
+}
+
+// zoomRange returns the minimum and maximum zoom levels at which tiles containing any of 'layers' are stored in
+// the archive. These are the zoom levels defined by 'header' narrowed by the layers' own zoom ranges, if present.
+func (md *archiveMetadata) zoomRange(layers []string, header pmtiles.HeaderV3) (int, int) {
+
+	min_zoom := int(header.MinZoom)
+	max_zoom := int(header.MaxZoom)
+
+	layers_min := -1
+	layers_max := -1
+
+	for _, name := range layers {
+
+		l, exists := md.vectorLayer(name)
+
+		if !exists || l.MinZoom == nil || l.MaxZoom == nil {
+			return min_zoom, max_zoom
+		}
+
+		if layers_min == -1 || *l.MinZoom < layers_min {
+			layers_min = *l.MinZoom
+		}
+
+		if layers_max == -1 || *l.MaxZoom > layers_max {
+			layers_max = *l.MaxZoom
+		}
+	}
+
+	if layers_min > min_zoom && layers_min <= max_zoom {
+		min_zoom = layers_min
+	}
+
+	if layers_max != -1 && layers_max < max_zoom && layers_max >= min_zoom {
+		max_zoom = layers_max
+	}
+
+	return min_zoom, max_zoom
+}
+
+// readArchiveHeader returns the raw bytes of the header for the PMTiles archive 'key' in 'bucket' along with the
+// archive's etag, if present.
+func readArchiveHeader(ctx context.Context, bucket pmtiles.Bucket, key string) ([]byte, string, error) {
+
+	r, etag, status_code, err := bucket.NewRangeReaderEtag(ctx, key, 0, pmtiles.HeaderV3LenBytes, "")
+
+	if err != nil {
+
+		if status_code == 404 {
+			return nil, "", fmt.Errorf("PMTiles database %s not found", key)
+		}
+
+		return nil, "", fmt.Errorf("Failed to read header for %s, %w", key, err)
+	}
+
+	defer r.Close()
+
+	header, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read header for %s, %w", key, err)
+	}
+
+	if len(header) < pmtiles.HeaderV3LenBytes {
+		return nil, "", fmt.Errorf("Invalid header for %s", key)
+	}
+
+	return header, etag, nil
+}
+
+// archiveIdentity returns a string which uniquely identifies the PMTiles archive 'key'. It is derived from the
+// archive's etag, if present, and the bytes of its header which, among other things, encode the offsets and lengths
+// of the archive's directories and tile data.
+func archiveIdentity(key string, etag string, header []byte) string {
+
+	h := sha256.New()
+	h.Write([]byte(key))
+	h.Write([]byte(etag))
+	h.Write(header)
+
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// tileArchive is a PMTiles archive that tiles are read from along with the details, derived from its header and
+// metadata, needed to read them.
+type tileArchive struct {
+	// The name of the archive, without a ".pmtiles" extension
+	name string
+	// A string which uniquely identifies the archive, see archiveIdentity
+	id     string
+	header pmtiles.HeaderV3
+	// The minimum and maximum zoom levels at which tiles for the layers being queried are stored
+	min_zoom int
+	max_zoom int
+}
+
+// loadTileArchive reads the header and metadata for the PMTiles archive 'name' and ensures that its tiles can be
+// decompressed and that it contains 'layers'.
+func loadTileArchive(ctx context.Context, bucket pmtiles.Bucket, name string, layers []string) (*tileArchive, error) {
+
+	key := fmt.Sprintf("%s.pmtiles", name)
+
+	body, etag, err := readArchiveHeader(ctx, bucket, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := pmtiles.DeserializeHeader(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to deserialize header for %s, %w", key, err)
+	}
+
+	metadata, err := readArchiveMetadataWithHeader(ctx, bucket, key, header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ensureTileDecompressor(header.TileCompression)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s database, %w", name, err)
+	}
+
+	for _, l := range layers {
+
+		err = metadata.validateLayer(l)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s database, %w", name, err)
+		}
+	}
+
+	min_zoom, max_zoom := metadata.zoomRange(layers, header)
+
+	a := &tileArchive{
+		name:     name,
+		id:       archiveIdentity(key, etag, body),
+		header:   header,
+		min_zoom: min_zoom,
+		max_zoom: max_zoom,
+	}
+
+	return a, nil
+}
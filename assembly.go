@@ -0,0 +1,503 @@
+package pmtiles
+
+// Assemble the clipped geometries of features which span multiple tiles in to a single geometry
+// as tiles are read. The assembled feature is stored by the feature cache along with the list of
+// tiles it was assembled from and the list of (adjacent) tiles which the feature is known to extend
+// in to but which haven't been read yet. Features with pending tiles are marked as partial when
+// they are read.
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/peterstace/simplefeatures/geom"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pmtiles/cache"
+)
+
+// PARTIAL_GEOMETRY_PROPERTY is the name of the property assigned to features, returned by the `Read` method, whose
+// geometries have only been assembled from some of the tiles they span.
+const PARTIAL_GEOMETRY_PROPERTY string = "pmtiles:partial"
+
+// The properties used to track the tiles a cached feature has been assembled from and the tiles which it
+// extends in to that have not been read yet.
+const assembly_tiles_property string = "pmtiles:tiles"
+const assembly_pending_property string = "pmtiles:pending"
+
+// The property used to track the epoch of the router (see reload.go) used to read the tiles a cached feature
+// has been assembled from. Cached features with a different epoch than the current router are ignored.
+const assembly_epoch_property string = "pmtiles:epoch"
+
+// featurePiece is the (decoded) GeoJSON-encoding of a feature as it appears, clipped, in a single tile.
+type featurePiece struct {
+	tile maptile.Tile
+	body []byte
+}
+
+// assemblyLocks is a fixed set of mutexes used to ensure that the same feature is not assembled
+// concurrently. Features are assigned to a mutex by hashing their ID.
+type assemblyLocks []*sync.Mutex
+
+func newAssemblyLocks(count int) assemblyLocks {
+
+	locks := make([]*sync.Mutex, count)
+
+	for i := 0; i < count; i++ {
+		locks[i] = new(sync.Mutex)
+	}
+
+	return locks
+}
+
+func (l assemblyLocks) lock(id string) *sync.Mutex {
+
+	h := fnv.New32a()
+	h.Write([]byte(id))
+
+	mu := l[h.Sum32()%uint32(len(l))]
+	mu.Lock()
+
+	return mu
+}
+
+// assembleFeature merges the geometries of 'pieces', all of which are assumed to be the same feature read
+// from different tiles, with the geometry of any previously cached version of that feature and stores the
+// result in the feature cache. Pieces from tiles which have already been merged are ignored. 'epoch' is the
+// epoch of the router used to read the tiles. If the databases have been reloaded since then the pieces are
+// ignored and previously cached versions of the feature read from different databases are replaced.
+func (db *PMTilesSpatialDatabase) assembleFeature(ctx context.Context, epoch string, pieces ...*featurePiece) error {
+
+	if len(pieces) == 0 {
+		return nil
+	}
+
+	if epoch != db.router.Load().epoch {
+		return nil
+	}
+
+	id, err := cache.FeatureIdFromBytes(pieces[0].body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to derive feature ID, %w", err)
+	}
+
+	mu := db.assembly_locks.lock(id)
+	defer mu.Unlock()
+
+	var body []byte
+
+	geoms := make([]orb.Geometry, 0)
+	seen := make(map[string]bool)
+	pending := make(map[string]bool)
+
+	// There is no way to distinguish between a feature which has not been cached and
+	// an error retrieving it so assume the former. This is not a request for the feature
+	// so it is not counted as a cache hit or miss.
+
+	fc, err := db.cache_manager.GetFeatureCache(cache.WithoutStats(ctx), id)
+
+	if err == nil && isCurrentFeatureCache(fc, epoch) {
+
+		body = []byte(fc.Body)
+
+		for _, r := range gjson.GetBytes(body, "properties."+assembly_tiles_property).Array() {
+			seen[r.String()] = true
+		}
+
+		for _, r := range gjson.GetBytes(body, "properties."+assembly_pending_property).Array() {
+			pending[r.String()] = true
+		}
+
+		g, err := geometryFromBody(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive geometry for cached feature %s, %w", id, err)
+		}
+
+		geoms = append(geoms, g)
+	}
+
+	added := 0
+
+	for _, p := range pieces {
+
+		key := tileKey(p.tile)
+
+		if seen[key] {
+			continue
+		}
+
+		g, err := geometryFromBody(p.body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive geometry for feature %s in tile %s, %w", id, key, err)
+		}
+
+		geoms = append(geoms, g)
+		seen[key] = true
+
+		for _, t := range tilesReached(p.tile, g) {
+			pending[tileKey(t)] = true
+		}
+
+		if body == nil {
+			body = p.body
+		}
+
+		added += 1
+	}
+
+	if added == 0 {
+		return nil
+	}
+
+	for key, _ := range seen {
+		delete(pending, key)
+	}
+
+	assembled_geom, err := unionGeometries(geoms...)
+
+	if err != nil {
+		slog.Warn("Failed to union geometries, falling back to combining them", "id", id, "error", err)
+		assembled_geom = combineGeometries(geoms...)
+	}
+
+	enc_geom, err := geojson.NewGeometry(assembled_geom).MarshalJSON()
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal geometry for feature %s, %w", id, err)
+	}
+
+	body, err = sjson.SetRawBytes(body, "geometry", enc_geom)
+
+	if err != nil {
+		return fmt.Errorf("Failed to assign geometry for feature %s, %w", id, err)
+	}
+
+	path := fmt.Sprintf("properties.%s", assembly_epoch_property)
+	body, err = sjson.SetBytes(body, path, epoch)
+
+	if err != nil {
+		return fmt.Errorf("Failed to assign %s for feature %s, %w", path, id, err)
+	}
+
+	to_assign := map[string]map[string]bool{
+		assembly_tiles_property:   seen,
+		assembly_pending_property: pending,
+	}
+
+	for prop, keys := range to_assign {
+
+		values := make([]string, 0, len(keys))
+
+		for k, _ := range keys {
+			values = append(values, k)
+		}
+
+		slices.Sort(values)
+
+		path := fmt.Sprintf("properties.%s", prop)
+		body, err = sjson.SetBytes(body, path, values)
+
+		if err != nil {
+			return fmt.Errorf("Failed to assign %s for feature %s, %w", path, id, err)
+		}
+	}
+
+	_, err = db.cache_manager.CacheFeature(ctx, body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to cache feature %s, %w", id, err)
+	}
+
+	return nil
+}
+
+// finalizeAssembledFeature removes the properties used to assemble the (cached) feature 'body' and, if the
+// feature's geometry has not been assembled from all the tiles it spans, assigns the `PARTIAL_GEOMETRY_PROPERTY`
+// property.
+func finalizeAssembledFeature(body []byte) ([]byte, error) {
+
+	pending := gjson.GetBytes(body, "properties."+assembly_pending_property).Array()
+
+	var err error
+
+	for _, prop := range []string{assembly_tiles_property, assembly_pending_property, assembly_epoch_property} {
+
+		path := fmt.Sprintf("properties.%s", prop)
+
+		if !gjson.GetBytes(body, path).Exists() {
+			continue
+		}
+
+		body, err = sjson.DeleteBytes(body, path)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to remove %s, %w", path, err)
+		}
+	}
+
+	if len(pending) > 0 {
+
+		path := fmt.Sprintf("properties.%s", PARTIAL_GEOMETRY_PROPERTY)
+		body, err = sjson.SetBytes(body, path, true)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to assign %s, %w", path, err)
+		}
+	}
+
+	return body, nil
+}
+
+// isCurrentFeatureCache reports whether the feature in 'fc' was assembled from tiles read using a router whose
+// epoch is 'epoch'.
+func isCurrentFeatureCache(fc *cache.FeatureCache, epoch string) bool {
+	return gjson.Get(fc.Body, "properties."+assembly_epoch_property).String() == epoch
+}
+
+// geometryFromBody returns the geometry of the GeoJSON-encoded feature 'body'.
+func geometryFromBody(body []byte) (orb.Geometry, error) {
+
+	rsp := gjson.GetBytes(body, "geometry")
+
+	if !rsp.Exists() {
+		return nil, fmt.Errorf("Missing geometry")
+	}
+
+	g, err := geojson.UnmarshalGeometry([]byte(rsp.Raw))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal geometry, %w", err)
+	}
+
+	return g.Geometry(), nil
+}
+
+// union_precision is the number of decimal places that coordinates are snapped to before geometries are unioned.
+// Seven decimal places is roughly one centimetre which is far more precise than the coordinates encoded in MVT tiles
+// but enough to absorb the floating point errors introduced when those coordinates are projected back to WGS84. Without
+// it the edges that adjacent tiles share don't always line up exactly and the union is left with slivers along them.
+const union_precision int = 7
+
+// unionGeometries returns the union of 'geoms' dissolving any boundaries they share. If any of 'geoms' are polygons
+// then only the polygonal parts of the union are returned as either a `orb.Polygon` or a `orb.MultiPolygon`. Lines
+// or points in the union of polygonal features are artifacts of clipping. Otherwise the union is returned as-is.
+func unionGeometries(geoms ...orb.Geometry) (orb.Geometry, error) {
+
+	if len(geoms) == 1 {
+		return geoms[0], nil
+	}
+
+	simple_geoms := make([]geom.Geometry, len(geoms))
+
+	has_polygons := false
+
+	for i, g := range geoms {
+
+		switch g.(type) {
+		case orb.Polygon, orb.MultiPolygon, orb.Bound:
+			has_polygons = true
+		}
+
+		// Clipped geometries are frequently invalid, for example because clipping has
+		// produced rings which touch themselves, so don't insist on valid geometries
+		// here. Union will return an error if it can't make sense of them.
+
+		simple_g, err := geom.UnmarshalWKT(wkt.MarshalString(g), geom.NoValidate{})
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to convert geometry at offset %d, %w", i, err)
+		}
+
+		simple_geoms[i] = simple_g.SnapToGrid(union_precision)
+	}
+
+	simple_union, err := geom.UnionMany(simple_geoms)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to union geometries, %w", err)
+	}
+
+	if has_polygons {
+
+		simple_union, err = polygonalParts(simple_union)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = simple_union.Validate()
+
+	if err != nil {
+		return nil, fmt.Errorf("Union of geometries is invalid, %w", err)
+	}
+
+	union, err := wkt.Unmarshal(simple_union.AsText())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to convert union, %w", err)
+	}
+
+	return union, nil
+}
+
+// polygonalParts returns a `geom.Polygon` or `geom.MultiPolygon` geometry containing all the polygons in 'g'.
+func polygonalParts(g geom.Geometry) (geom.Geometry, error) {
+
+	polys := make([]geom.Polygon, 0)
+
+	for _, part := range g.Dump() {
+
+		switch part.Type() {
+		case geom.TypePolygon:
+
+			poly, _ := part.AsPolygon()
+
+			if !poly.IsEmpty() {
+				polys = append(polys, poly)
+			}
+
+		default:
+			// pass, see notes in unionGeometries
+		}
+	}
+
+	switch len(polys) {
+	case 0:
+		return geom.Geometry{}, fmt.Errorf("Union of geometries does not contain any polygons")
+	case 1:
+		return polys[0].AsGeometry(), nil
+	default:
+		return geom.NewMultiPolygon(polys).AsGeometry(), nil
+	}
+}
+
+// combineGeometries returns a single geometry containing all of 'geoms' without dissolving any boundaries they share.
+// It is used when 'geoms' can not be unioned. Polygons are returned as a `orb.MultiPolygon` unless 'geoms' contains
+// other geometry types in which case a `orb.Collection` is returned.
+func combineGeometries(geoms ...orb.Geometry) orb.Geometry {
+
+	polys := make([]orb.Polygon, 0)
+	other := make([]orb.Geometry, 0)
+
+	for _, g := range geoms {
+
+		switch v := g.(type) {
+		case orb.Polygon:
+			polys = append(polys, v)
+		case orb.MultiPolygon:
+			polys = append(polys, v...)
+		case orb.Bound:
+			polys = append(polys, v.ToPolygon())
+		default:
+			other = append(other, g)
+		}
+	}
+
+	if len(other) == 0 {
+		return orb.MultiPolygon(polys)
+	}
+
+	collection := orb.Collection(other)
+
+	if len(polys) > 0 {
+		collection = append(collection, orb.MultiPolygon(polys))
+	}
+
+	return collection
+}
+
+// tilesReached returns the tiles adjacent to 't' which 'g', a geometry clipped to 't', extends in to. A geometry
+// is assumed to extend in to an adjacent tile if its bounding box reaches the edge of 't' that tile shares.
+func tilesReached(t maptile.Tile, g orb.Geometry) []maptile.Tile {
+
+	tile_bound := t.Bound()
+	g_bound := g.Bound()
+
+	// Allow for the precision of coordinates in an MVT tile with the default extent of 4096
+
+	eps_x := (tile_bound.Max.X() - tile_bound.Min.X()) / 4096
+	eps_y := (tile_bound.Max.Y() - tile_bound.Min.Y()) / 4096
+
+	west := g_bound.Min.X() <= tile_bound.Min.X()+eps_x
+	east := g_bound.Max.X() >= tile_bound.Max.X()-eps_x
+	south := g_bound.Min.Y() <= tile_bound.Min.Y()+eps_y
+	north := g_bound.Max.Y() >= tile_bound.Max.Y()-eps_y
+
+	dx := make([]int64, 0)
+	dy := make([]int64, 0)
+
+	if west {
+		dx = append(dx, -1)
+	}
+
+	if east {
+		dx = append(dx, 1)
+	}
+
+	// Tile rows increase from north to south
+
+	if north {
+		dy = append(dy, -1)
+	}
+
+	if south {
+		dy = append(dy, 1)
+	}
+
+	n := int64(1) << uint(t.Z)
+
+	neighbour := func(x int64, y int64) (maptile.Tile, bool) {
+
+		if y < 0 || y >= n {
+			return maptile.Tile{}, false
+		}
+
+		x = (x + n) % n
+		return maptile.New(uint32(x), uint32(y), t.Z), true
+	}
+
+	tiles := make([]maptile.Tile, 0)
+
+	candidates := make([][2]int64, 0)
+
+	for _, x := range dx {
+		candidates = append(candidates, [2]int64{x, 0})
+	}
+
+	for _, y := range dy {
+
+		candidates = append(candidates, [2]int64{0, y})
+
+		for _, x := range dx {
+			candidates = append(candidates, [2]int64{x, y})
+		}
+	}
+
+	for _, c := range candidates {
+
+		nt, ok := neighbour(int64(t.X)+c[0], int64(t.Y)+c[1])
+
+		if ok && nt != t {
+			tiles = append(tiles, nt)
+		}
+	}
+
+	return tiles
+}
+
+// tileKey returns a string representation of 't'.
+func tileKey(t maptile.Tile) string {
+	return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y)
+}
@@ -0,0 +1,231 @@
+package pmtiles
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/planar"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pmtiles/cache"
+)
+
+func TestUnionGeometries(t *testing.T) {
+
+	left := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}.ToPolygon()
+	right := orb.Bound{Min: orb.Point{1, 0}, Max: orb.Point{2, 1}}.ToPolygon()
+
+	g, err := unionGeometries(left, right)
+
+	if err != nil {
+		t.Fatalf("Failed to union geometries, %v", err)
+	}
+
+	poly, ok := g.(orb.Polygon)
+
+	if !ok {
+		t.Fatalf("Unexpected geometry type %s", g.GeoJSONType())
+	}
+
+	if len(poly) != 1 {
+		t.Fatalf("Unexpected number of rings (%d)", len(poly))
+	}
+
+	area := planar.Area(poly)
+
+	if area != 2 {
+		t.Fatalf("Unexpected area (%f)", area)
+	}
+}
+
+func TestUnionGeometriesSeams(t *testing.T) {
+
+	// Three pieces of the same polygon read from adjacent tiles. The first two overlap
+	// (as is the case for tiles with a buffer) and the third shares an edge with the second
+	// but with coordinates that are off by a rounding error.
+
+	pieces := []orb.Geometry{
+		orb.Bound{Min: orb.Point{-122.5, 37.7}, Max: orb.Point{-122.39, 37.8}}.ToPolygon(),
+		orb.Bound{Min: orb.Point{-122.41, 37.7}, Max: orb.Point{-122.3, 37.8}}.ToPolygon(),
+		orb.Bound{Min: orb.Point{-122.3 - 1e-12, 37.7}, Max: orb.Point{-122.2, 37.8 + 1e-12}}.ToPolygon(),
+		// A line left over from clipping a polygon to the edge of a tile
+		orb.LineString{orb.Point{-122.2, 37.7}, orb.Point{-122.2, 37.75}},
+	}
+
+	g, err := unionGeometries(pieces...)
+
+	if err != nil {
+		t.Fatalf("Failed to union geometries, %v", err)
+	}
+
+	poly, ok := g.(orb.Polygon)
+
+	if !ok {
+		t.Fatalf("Unexpected geometry type %s", g.GeoJSONType())
+	}
+
+	if len(poly) != 1 {
+		t.Fatalf("Unexpected number of rings (%d)", len(poly))
+	}
+
+	area := planar.Area(poly)
+	expected := 0.3 * 0.1
+
+	if math.Abs(area-expected) > 1e-9 {
+		t.Fatalf("Unexpected area (%f), expected %f", area, expected)
+	}
+
+	// Non-polygonal geometries should not be dropped when they can't be unioned
+
+	combined := combineGeometries(pieces...)
+
+	collection, ok := combined.(orb.Collection)
+
+	if !ok {
+		t.Fatalf("Unexpected geometry type %s", combined.GeoJSONType())
+	}
+
+	if len(collection) != 2 {
+		t.Fatalf("Unexpected number of geometries (%d)", len(collection))
+	}
+}
+
+func TestAssembleFeature(t *testing.T) {
+
+	ctx := context.Background()
+
+	cache_manager, err := cache.NewCacheManager(ctx, "sql://sqlite?dsn={tmp}")
+
+	if err != nil {
+		t.Fatalf("Failed to create cache manager, %v", err)
+	}
+
+	defer cache_manager.Close()
+
+	db := &PMTilesSpatialDatabase{
+		enable_feature_cache: true,
+		cache_manager:        cache_manager,
+		assembly_locks:       newAssemblyLocks(1),
+	}
+
+	db.router.Store(&tileRouter{epoch: "a"})
+
+	// A feature spanning two adjacent tiles
+
+	west := maptile.New(1308, 3166, 13)
+	east := maptile.New(1309, 3166, 13)
+
+	west_bound := west.Bound()
+	east_bound := east.Bound()
+
+	pieces := []*featurePiece{
+		newTestFeaturePiece(t, west, west_bound.Pad(-0.001).Extend(orb.Point{west_bound.Max.X(), west_bound.Center().Y()})),
+		newTestFeaturePiece(t, east, east_bound.Pad(-0.001).Extend(orb.Point{east_bound.Min.X(), east_bound.Center().Y()})),
+	}
+
+	original := string(pieces[0].body)
+
+	err = db.assembleFeature(ctx, "a", pieces[0])
+
+	if err != nil {
+		t.Fatalf("Failed to assemble feature, %v", err)
+	}
+
+	body := readTestFeature(t, db)
+
+	if !gjson.GetBytes(body, "properties."+PARTIAL_GEOMETRY_PROPERTY).Bool() {
+		t.Fatalf("Expected feature to be marked as partial")
+	}
+
+	err = db.assembleFeature(ctx, "a", pieces[1])
+
+	if err != nil {
+		t.Fatalf("Failed to assemble feature, %v", err)
+	}
+
+	body = readTestFeature(t, db)
+
+	if gjson.GetBytes(body, "properties."+PARTIAL_GEOMETRY_PROPERTY).Exists() {
+		t.Fatalf("Expected feature not to be marked as partial")
+	}
+
+	if gjson.GetBytes(body, "properties."+assembly_tiles_property).Exists() {
+		t.Fatalf("Expected %s property to be removed", assembly_tiles_property)
+	}
+
+	if string(pieces[0].body) != original {
+		t.Fatalf("Feature piece was modified")
+	}
+
+	geom_type := gjson.GetBytes(body, "geometry.type").String()
+
+	if geom_type != "Polygon" {
+		t.Fatalf("Unexpected geometry type %s", geom_type)
+	}
+
+	// Features cached before the databases were reloaded are stale
+
+	db.router.Store(&tileRouter{epoch: "b"})
+
+	_, err = db.Read(ctx, fmt.Sprintf("%d.geojson", 1234))
+
+	if err == nil {
+		t.Fatalf("Expected feature cached from previous databases to be ignored")
+	}
+
+	err = db.assembleFeature(ctx, "b", pieces[0])
+
+	if err != nil {
+		t.Fatalf("Failed to assemble feature, %v", err)
+	}
+
+	body = readTestFeature(t, db)
+
+	if !gjson.GetBytes(body, "properties."+PARTIAL_GEOMETRY_PROPERTY).Bool() {
+		t.Fatalf("Expected feature to be assembled from scratch")
+	}
+}
+
+func newTestFeaturePiece(t *testing.T, tile maptile.Tile, b orb.Bound) *featurePiece {
+
+	f := geojson.NewFeature(b.ToPolygon())
+	f.Properties["wof:id"] = 1234
+	f.Properties["wof:name"] = "Test"
+
+	body, err := f.MarshalJSON()
+
+	if err != nil {
+		t.Fatalf("Failed to marshal feature, %v", err)
+	}
+
+	return &featurePiece{
+		tile: tile,
+		body: body,
+	}
+}
+
+func readTestFeature(t *testing.T, db *PMTilesSpatialDatabase) []byte {
+
+	ctx := context.Background()
+
+	r, err := db.Read(ctx, fmt.Sprintf("%d.geojson", 1234))
+
+	if err != nil {
+		t.Fatalf("Failed to read feature, %v", err)
+	}
+
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		t.Fatalf("Failed to read feature, %v", err)
+	}
+
+	return body
+}
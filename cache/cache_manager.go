@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"fmt"
+	"iter"
 	"net/url"
 	"sort"
 	"strings"
@@ -13,6 +14,14 @@ import (
 type CacheManager interface {
 	CacheFeature(context.Context, []byte) (*FeatureCache, error)
 	GetFeatureCache(context.Context, string) (*FeatureCache, error)
+	// DeleteFeatureCache removes the feature with a given ID from the cache. It is not an error if the feature is not present.
+	DeleteFeatureCache(context.Context, string) error
+	// Purge removes all the features from the cache.
+	Purge(context.Context) error
+	// Stats returns statistics about the cache. Hits and misses are counted since the cache manager was created.
+	Stats(context.Context) (*CacheStats, error)
+	// FeatureCacheIds returns an iterator of the IDs of all the features in the cache.
+	FeatureCacheIds(context.Context) iter.Seq2[string, error]
 	Close() error
 }
 
@@ -0,0 +1,597 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-uri"
+	"gocloud.dev/blob"
+	_ "gocloud.dev/blob/fileblob"
+	"gocloud.dev/gcerrors"
+)
+
+const (
+	// BLOB_LAYOUT_ID stores features in a directory tree derived from their Who's On First ID, for example
+	// "859/225/83/85922583.geojson".
+	BLOB_LAYOUT_ID string = "id"
+	// BLOB_LAYOUT_HASH stores features in a directory tree derived from the SHA-256 hash of their ID, for example
+	// "5d/41/5d41402a...geojson", which distributes features evenly across key prefixes.
+	BLOB_LAYOUT_HASH string = "hash"
+)
+
+// The names of the metadata properties assigned to each feature.
+const (
+	blob_metadata_created string = "created"
+	blob_metadata_id      string = "id"
+)
+
+func init() {
+
+	ctx := context.Background()
+	err := RegisterCacheManager(ctx, "blob", NewBlobCacheManager)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// BlobCacheManager implements the `CacheManager` interface storing features as individual files in a
+// `gocloud.dev/blob` bucket. Unlike `DocstoreCacheManager` there is no limit on the size of a feature.
+type BlobCacheManager struct {
+	statsCounter
+	bucket     *blob.Bucket
+	layout     string
+	cache_ttl  int
+	ticker     *time.Ticker
+	done       chan bool
+	wg         *sync.WaitGroup
+	close_once *sync.Once
+}
+
+type BlobCacheManagerOptions struct {
+	Bucket *blob.Bucket
+	// The layout used to derive the keys for features. One of BLOB_LAYOUT_ID or BLOB_LAYOUT_HASH.
+	Layout string
+	// The number of seconds that features should persist. A value of 0 means features never expire.
+	CacheTTL int
+	// The number of seconds between attempts to remove expired features in the background. A value of 0 disables
+	// pruning in the background.
+	PruneInterval int
+}
+
+func NewBlobCacheManager(ctx context.Context, uri string) (CacheManager, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	bucket_uri := q.Get("bucket-uri")
+
+	if bucket_uri == "" {
+		return nil, fmt.Errorf("Missing ?bucket-uri= parameter")
+	}
+
+	layout := BLOB_LAYOUT_ID
+
+	if q.Has("layout") {
+
+		layout = q.Get("layout")
+
+		switch layout {
+		case BLOB_LAYOUT_ID, BLOB_LAYOUT_HASH:
+			// pass
+		default:
+			return nil, fmt.Errorf("Invalid ?layout= parameter, must be one of %s or %s", BLOB_LAYOUT_ID, BLOB_LAYOUT_HASH)
+		}
+	}
+
+	ttl := 0
+
+	if q.Has("ttl") {
+
+		v, err := strconv.Atoi(q.Get("ttl"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?ttl= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?ttl= parameter, must be zero or greater")
+		}
+
+		ttl = v
+	}
+
+	prune_interval := ttl
+
+	if q.Has("prune-interval") {
+
+		v, err := strconv.Atoi(q.Get("prune-interval"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?prune-interval= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?prune-interval= parameter, must be zero or greater")
+		}
+
+		prune_interval = v
+	}
+
+	bucket, err := blob.OpenBucket(ctx, bucket_uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket, %w", err)
+	}
+
+	prefix := q.Get("prefix")
+
+	if prefix != "" {
+		bucket = blob.PrefixedBucket(bucket, prefix)
+	}
+
+	opts := &BlobCacheManagerOptions{
+		Bucket:        bucket,
+		Layout:        layout,
+		CacheTTL:      ttl,
+		PruneInterval: prune_interval,
+	}
+
+	return NewBlobCacheManagerWithOptions(ctx, opts), nil
+}
+
+// NewBlobCacheManagerWithOptions returns a new `BlobCacheManager` instance for 'opts'. If 'opts' defines a TTL then
+// expired features are pruned once at startup and then every 'opts.PruneInterval' seconds until the Close method is
+// invoked.
+func NewBlobCacheManagerWithOptions(ctx context.Context, opts *BlobCacheManagerOptions) *BlobCacheManager {
+
+	layout := opts.Layout
+
+	if layout == "" {
+		layout = BLOB_LAYOUT_ID
+	}
+
+	m := &BlobCacheManager{
+		bucket:     opts.Bucket,
+		layout:     layout,
+		cache_ttl:  opts.CacheTTL,
+		done:       make(chan bool),
+		wg:         new(sync.WaitGroup),
+		close_once: new(sync.Once),
+	}
+
+	if m.cache_ttl == 0 {
+		return m
+	}
+
+	err := m.Prune(ctx)
+
+	if err != nil {
+		slog.Error("Failed to prune feature cache", "error", err)
+	}
+
+	if opts.PruneInterval == 0 {
+		return m
+	}
+
+	ticker := time.NewTicker(time.Duration(opts.PruneInterval) * time.Second)
+	m.ticker = ticker
+
+	m.wg.Add(1)
+
+	go func() {
+
+		defer m.wg.Done()
+
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+
+				err := m.Prune(ctx)
+
+				if err != nil {
+					slog.Error("Failed to prune feature cache", "error", err)
+				}
+			}
+		}
+	}()
+
+	return m
+}
+
+func (m *BlobCacheManager) CacheFeature(ctx context.Context, body []byte) (*FeatureCache, error) {
+
+	fc, err := NewFeatureCache(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create feature cache, %w", err)
+	}
+
+	key, err := m.featureKey(fc.Id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	wr_opts := &blob.WriterOptions{
+		ContentType: "application/geo+json",
+		Metadata: map[string]string{
+			blob_metadata_created: strconv.FormatInt(fc.Created, 10),
+			blob_metadata_id:      fc.Id,
+		},
+	}
+
+	err = m.bucket.WriteAll(ctx, key, body, wr_opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to store feature cache for %s, %w", fc.Id, err)
+	}
+
+	return fc, nil
+}
+
+func (m *BlobCacheManager) GetFeatureCache(ctx context.Context, id string) (*FeatureCache, error) {
+
+	key, err := m.featureKey(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := m.bucket.Attributes(ctx, key)
+
+	if err != nil {
+
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			m.miss(ctx)
+		}
+
+		return nil, fmt.Errorf("Failed to retrieve feature from cache for %s, %w", id, err)
+	}
+
+	created := createdFromAttributes(attrs)
+
+	if m.cache_ttl > 0 && created <= m.expires().Unix() {
+		m.miss(ctx)
+		return nil, fmt.Errorf("Failed to retrieve feature from cache for %s, feature has expired", id)
+	}
+
+	r, err := m.bucket.NewReader(ctx, key, nil)
+
+	if err != nil {
+
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			m.miss(ctx)
+		}
+
+		return nil, fmt.Errorf("Failed to retrieve feature from cache for %s, %w", id, err)
+	}
+
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read feature from cache for %s, %w", id, err)
+	}
+
+	m.hit(ctx)
+
+	fc := &FeatureCache{
+		Created: created,
+		Id:      id,
+		Body:    string(body),
+	}
+
+	return fc, nil
+}
+
+// DeleteFeatureCache removes the feature with ID 'id' from the cache.
+func (m *BlobCacheManager) DeleteFeatureCache(ctx context.Context, id string) error {
+
+	key, err := m.featureKey(id)
+
+	if err != nil {
+		return err
+	}
+
+	err = m.bucket.Delete(ctx, key)
+
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return fmt.Errorf("Failed to remove %s from feature cache, %w", id, err)
+	}
+
+	return nil
+}
+
+// Purge removes all the features from the cache.
+func (m *BlobCacheManager) Purge(ctx context.Context) error {
+
+	// Gather the keys to remove before removing them since not all drivers support
+	// modifying a bucket while it is being listed.
+
+	keys := make([]string, 0)
+
+	for obj, err := range m.listFeatures(ctx) {
+
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, obj.Key)
+	}
+
+	return m.deleteKeys(ctx, keys)
+}
+
+// Prune removes features whose "created" metadata property is older than the TTL defined when 'm' was created.
+// The attributes of every feature in the bucket are read so this may be slow (and expensive) for large buckets.
+func (m *BlobCacheManager) Prune(ctx context.Context) error {
+
+	if m.cache_ttl == 0 {
+		return nil
+	}
+
+	then := m.expires()
+
+	slog.Debug("Prune feature cache", "older than", then)
+
+	keys := make([]string, 0)
+
+	for obj, err := range m.listFeatures(ctx) {
+
+		if err != nil {
+			return err
+		}
+
+		attrs, err := m.bucket.Attributes(ctx, obj.Key)
+
+		if err != nil {
+
+			if gcerrors.Code(err) == gcerrors.NotFound {
+				continue
+			}
+
+			return fmt.Errorf("Failed to retrieve attributes for %s, %w", obj.Key, err)
+		}
+
+		created := createdFromAttributes(attrs)
+
+		if created > then.Unix() {
+			continue
+		}
+
+		slog.Debug("Remove from feature cache", "key", obj.Key, "created", created)
+		keys = append(keys, obj.Key)
+	}
+
+	return m.deleteKeys(ctx, keys)
+}
+
+// deleteKeys removes 'keys' from the bucket. It is not an error if a key does not exist.
+func (m *BlobCacheManager) deleteKeys(ctx context.Context, keys []string) error {
+
+	for _, key := range keys {
+
+		err := m.bucket.Delete(ctx, key)
+
+		if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+			return fmt.Errorf("Failed to remove %s from feature cache, %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// Stats returns statistics about the cache. Every key in the bucket is listed in order to count entries and bytes
+// so this may be slow for large buckets. Features which have expired but have not been pruned yet are not counted
+// which means the attributes of every feature are also read if a TTL is defined.
+func (m *BlobCacheManager) Stats(ctx context.Context) (*CacheStats, error) {
+
+	count := int64(0)
+	size := int64(0)
+
+	then := m.expires().Unix()
+
+	for obj, err := range m.listFeatures(ctx) {
+
+		if err != nil {
+			return nil, err
+		}
+
+		if m.cache_ttl > 0 {
+
+			attrs, err := m.bucket.Attributes(ctx, obj.Key)
+
+			if err != nil {
+
+				if gcerrors.Code(err) == gcerrors.NotFound {
+					continue
+				}
+
+				return nil, fmt.Errorf("Failed to retrieve attributes for %s, %w", obj.Key, err)
+			}
+
+			if createdFromAttributes(attrs) <= then {
+				continue
+			}
+		}
+
+		count += 1
+		size += obj.Size
+	}
+
+	return m.stats(count, size), nil
+}
+
+// FeatureCacheIds returns an iterator of the IDs of all the features in the cache. For the BLOB_LAYOUT_HASH layout
+// the attributes of every feature are read in order to determine its ID.
+func (m *BlobCacheManager) FeatureCacheIds(ctx context.Context) iter.Seq2[string, error] {
+
+	return func(yield func(string, error) bool) {
+
+		for obj, err := range m.listFeatures(ctx) {
+
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			var id string
+
+			switch m.layout {
+			case BLOB_LAYOUT_HASH:
+
+				attrs, err := m.bucket.Attributes(ctx, obj.Key)
+
+				if err != nil {
+
+					if gcerrors.Code(err) == gcerrors.NotFound {
+						continue
+					}
+
+					yield("", fmt.Errorf("Failed to retrieve attributes for %s, %w", obj.Key, err))
+					return
+				}
+
+				id = attrs.Metadata[blob_metadata_id]
+
+			default:
+				id = strings.TrimSuffix(path.Base(obj.Key), ".geojson")
+			}
+
+			if id == "" {
+				continue
+			}
+
+			if !yield(id, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Close stops pruning features in the background and closes the underlying bucket. It is safe to call Close
+// more than once.
+func (m *BlobCacheManager) Close() error {
+
+	var err error
+
+	m.close_once.Do(func() {
+
+		if m.ticker != nil {
+			m.ticker.Stop()
+		}
+
+		close(m.done)
+		m.wg.Wait()
+
+		err = m.bucket.Close()
+	})
+
+	return err
+}
+
+// createdFromAttributes returns the Unix timestamp recorded in the "created" metadata property of 'attrs' falling
+// back to the modification time for features stored without it.
+func createdFromAttributes(attrs *blob.Attributes) int64 {
+
+	v, exists := attrs.Metadata[blob_metadata_created]
+
+	if exists {
+
+		ts, err := strconv.ParseInt(v, 10, 64)
+
+		if err == nil {
+			return ts
+		}
+	}
+
+	return attrs.ModTime.Unix()
+}
+
+// featureKey returns the key for the feature with ID 'id' derived from the layout defined when 'm' was created.
+func (m *BlobCacheManager) featureKey(id string) (string, error) {
+
+	fname := fmt.Sprintf("%s.geojson", id)
+
+	switch m.layout {
+	case BLOB_LAYOUT_HASH:
+
+		hash := fmt.Sprintf("%x", sha256.Sum256([]byte(id)))
+		return path.Join(hash[0:2], hash[2:4], hash+".geojson"), nil
+
+	default:
+
+		// IDs for alternate geometries take the form of {ID}-alt-{LABEL}, see FeatureIdFromBytes
+
+		str_id, _, _ := strings.Cut(id, "-")
+
+		wof_id, err := strconv.ParseInt(str_id, 10, 64)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to derive key for %s, invalid ID", id)
+		}
+
+		root, err := uri.Id2Path(wof_id)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to derive key for %s, %w", id, err)
+		}
+
+		return path.Join(root, fname), nil
+	}
+}
+
+// listFeatures returns an iterator of all the features in the bucket.
+func (m *BlobCacheManager) listFeatures(ctx context.Context) iter.Seq2[*blob.ListObject, error] {
+
+	return func(yield func(*blob.ListObject, error) bool) {
+
+		iter := m.bucket.List(nil)
+
+		for {
+
+			obj, err := iter.Next(ctx)
+
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				yield(nil, fmt.Errorf("Failed to list features, %w", err))
+				return
+			}
+
+			if obj.IsDir || !strings.HasSuffix(obj.Key, ".geojson") {
+				continue
+			}
+
+			if !yield(obj, nil) {
+				return
+			}
+		}
+	}
+}
+
+// expires returns the time at, or before, which features are considered to have expired.
+func (m *BlobCacheManager) expires() time.Time {
+	return time.Now().Add(time.Duration(-m.cache_ttl) * time.Second)
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+func TestBlobCacheManager(t *testing.T) {
+
+	ctx := context.Background()
+
+	body := []byte(`{"type":"Feature","properties":{"wof:id":85922583,"src:alt_label":"quattroshapes"},"geometry":null}`)
+
+	tests := map[string]string{
+		"id":   "859/225/83/85922583-alt-quattroshapes.geojson",
+		"hash": "",
+	}
+
+	for layout, expected_key := range tests {
+
+		root := t.TempDir()
+
+		uri := fmt.Sprintf("blob://?bucket-uri=%s&layout=%s&ttl=60&prune-interval=0", url.QueryEscape("file://"+root), layout)
+
+		cm, err := NewCacheManager(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create cache manager for %s, %v", uri, err)
+		}
+
+		m := cm.(*BlobCacheManager)
+
+		fc, err := m.CacheFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to cache feature for %s, %v", uri, err)
+		}
+
+		if fc.Id != "85922583-alt-quattroshapes" {
+			t.Fatalf("Unexpected ID for %s, %s", uri, fc.Id)
+		}
+
+		if expected_key != "" {
+
+			_, err := os.Stat(filepath.Join(root, expected_key))
+
+			if err != nil {
+				t.Fatalf("Expected feature to be stored at %s for %s, %v", expected_key, uri, err)
+			}
+		}
+
+		cached_fc, err := m.GetFeatureCache(ctx, fc.Id)
+
+		if err != nil {
+			t.Fatalf("Failed to retrieve feature for %s, %v", uri, err)
+		}
+
+		if cached_fc.Body != string(body) {
+			t.Fatalf("Unexpected body for %s, %s", uri, cached_fc.Body)
+		}
+
+		for id, err := range m.FeatureCacheIds(ctx) {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate IDs for %s, %v", uri, err)
+			}
+
+			if id != fc.Id {
+				t.Fatalf("Unexpected ID for %s, %s", uri, id)
+			}
+		}
+
+		// Features are expired and pruned using their "created" metadata property
+
+		key, err := m.featureKey(fc.Id)
+
+		if err != nil {
+			t.Fatalf("Failed to derive key for %s, %v", uri, err)
+		}
+
+		wr_opts := &blob.WriterOptions{
+			Metadata: map[string]string{
+				blob_metadata_created: strconv.FormatInt(time.Now().Add(-120*time.Second).Unix(), 10),
+				blob_metadata_id:      fc.Id,
+			},
+		}
+
+		err = m.bucket.WriteAll(ctx, key, body, wr_opts)
+
+		if err != nil {
+			t.Fatalf("Failed to write feature for %s, %v", uri, err)
+		}
+
+		_, err = m.GetFeatureCache(ctx, fc.Id)
+
+		if err == nil {
+			t.Fatalf("Expected expired feature to fail for %s", uri)
+		}
+
+		stats, err := m.Stats(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to derive stats for %s, %v", uri, err)
+		}
+
+		if stats.Entries != 0 || stats.Bytes != 0 {
+			t.Fatalf("Expected expired feature not to be counted for %s, %v", uri, stats)
+		}
+
+		err = m.Prune(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to prune features for %s, %v", uri, err)
+		}
+
+		exists, err := m.bucket.Exists(ctx, key)
+
+		if err != nil {
+			t.Fatalf("Failed to determine whether %s exists for %s, %v", key, uri, err)
+		}
+
+		if exists {
+			t.Fatalf("Expected expired feature to have been pruned for %s", uri)
+		}
+
+		err = m.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to close cache manager for %s, %v", uri, err)
+		}
+
+		err = m.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to close cache manager a second time for %s, %v", uri, err)
+		}
+	}
+
+	invalid := []string{
+		"blob://",
+		"blob://?bucket-uri=file:///tmp&layout=bogus",
+		"blob://?bucket-uri=file:///tmp&ttl=-1",
+	}
+
+	for _, uri := range invalid {
+
+		_, err := NewCacheManager(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to be invalid", uri)
+		}
+	}
+}
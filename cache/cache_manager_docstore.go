@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"iter"
 	"log/slog"
 	"net/url"
 	"strconv"
@@ -11,6 +12,8 @@ import (
 
 	aa_docstore "github.com/aaronland/gocloud-docstore"
 	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/gcerrors"
 )
 
 func init() {
@@ -34,7 +37,9 @@ func init() {
 }
 
 type DocstoreCacheManager struct {
+	statsCounter
 	feature_collection *docstore.Collection
+	cache_ttl          int
 	ticker             *time.Ticker
 }
 
@@ -53,12 +58,6 @@ func NewDocstoreCacheManager(ctx context.Context, uri string) (CacheManager, err
 
 	q := u.Query()
 
-	col, err := aa_docstore.OpenCollection(ctx, uri)
-
-	if err != nil {
-		return nil, fmt.Errorf("Failed to open docstore collection, %w", err)
-	}
-
 	ttl := 3600
 
 	if q.Has("ttl") {
@@ -72,6 +71,18 @@ func NewDocstoreCacheManager(ctx context.Context, uri string) (CacheManager, err
 		ttl = v
 	}
 
+	// The ?ttl= parameter is not a docstore parameter so remove it before opening the
+	// collection. Some drivers (mem://) will fail on unknown parameters.
+
+	q.Del("ttl")
+	u.RawQuery = q.Encode()
+
+	col, err := aa_docstore.OpenCollection(ctx, u.String())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open docstore collection, %w", err)
+	}
+
 	opts := &DocstoreCacheManagerOptions{
 		FeatureCollection: col,
 		CacheTTL:          ttl,
@@ -84,6 +95,7 @@ func NewDocstoreCacheManagerWithOptions(ctx context.Context, opts *DocstoreCache
 
 	m := &DocstoreCacheManager{
 		feature_collection: opts.FeatureCollection,
+		cache_ttl:          opts.CacheTTL,
 	}
 
 	cache_ttl := opts.CacheTTL
@@ -145,12 +157,184 @@ func (m *DocstoreCacheManager) GetFeatureCache(ctx context.Context, id string) (
 	err := m.feature_collection.Get(ctx, &fc)
 
 	if err != nil {
+
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			m.miss(ctx)
+		}
+
 		return nil, fmt.Errorf("Failed to retrieve feature from cache for %s, %w", id, err)
 	}
 
+	// Features which have expired but not been pruned yet are treated as missing
+
+	if fc.Created <= m.notExpiredAfter() {
+		m.miss(ctx)
+		return nil, fmt.Errorf("Failed to retrieve feature from cache for %s, feature has expired", id)
+	}
+
+	m.hit(ctx)
 	return &fc, nil
 }
 
+// DeleteFeatureCache removes the feature with ID 'id' from the cache.
+func (m *DocstoreCacheManager) DeleteFeatureCache(ctx context.Context, id string) error {
+
+	if m.feature_collection == nil {
+		return fmt.Errorf("No feature collection defined")
+	}
+
+	fc := FeatureCache{
+		Id: id,
+	}
+
+	err := m.feature_collection.Delete(ctx, &fc)
+
+	if err != nil && gcerrors.Code(err) != gcerrors.NotFound {
+		return fmt.Errorf("Failed to remove %s from feature cache, %w", id, err)
+	}
+
+	return nil
+}
+
+// Purge removes all the features from the cache. Features are read, and removed, one at a time so this
+// may be slow for large collections.
+func (m *DocstoreCacheManager) Purge(ctx context.Context) error {
+
+	if m.feature_collection == nil {
+		return fmt.Errorf("No feature collection defined")
+	}
+
+	// Gather the IDs to remove before removing them since not all drivers support
+	// modifying a collection while it is being queried.
+
+	ids := make([]string, 0)
+
+	for id, err := range m.featureIds(ctx, false) {
+
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+
+	for _, id := range ids {
+
+		err := m.DeleteFeatureCache(ctx, id)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stats returns statistics about the cache. Features which have expired but have not been pruned yet are not
+// counted. Every feature in the collection is read in order to count entries and bytes so this may be slow (and
+// expensive) for large collections.
+func (m *DocstoreCacheManager) Stats(ctx context.Context) (*CacheStats, error) {
+
+	if m.feature_collection == nil {
+		return nil, fmt.Errorf("No feature collection defined")
+	}
+
+	count := int64(0)
+	size := int64(0)
+
+	q := m.feature_collection.Query()
+	q = q.Where("Created", ">", m.notExpiredAfter())
+
+	iter := q.Get(ctx)
+	defer iter.Stop()
+
+	for {
+
+		var fc FeatureCache
+
+		err := iter.Next(ctx, &fc)
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to iterate feature cache, %w", err)
+		}
+
+		count += 1
+		size += int64(len(fc.Body))
+	}
+
+	return m.stats(count, size), nil
+}
+
+// FeatureCacheIds returns an iterator of the IDs of all the features in the cache.
+func (m *DocstoreCacheManager) FeatureCacheIds(ctx context.Context) iter.Seq2[string, error] {
+
+	return func(yield func(string, error) bool) {
+
+		if m.feature_collection == nil {
+			yield("", fmt.Errorf("No feature collection defined"))
+			return
+		}
+
+		for id, err := range m.featureIds(ctx, true) {
+
+			if !yield(id, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// featureIds returns an iterator of the IDs of the features in the cache. If 'exclude_expired' is true then
+// features which have expired but have not been pruned yet are excluded.
+func (m *DocstoreCacheManager) featureIds(ctx context.Context, exclude_expired bool) iter.Seq2[string, error] {
+
+	return func(yield func(string, error) bool) {
+
+		q := m.feature_collection.Query()
+
+		if exclude_expired {
+			q = q.Where("Created", ">", m.notExpiredAfter())
+		}
+
+		iter := q.Get(ctx, "Id")
+		defer iter.Stop()
+
+		for {
+
+			var fc FeatureCache
+
+			err := iter.Next(ctx, &fc)
+
+			if err == io.EOF {
+				return
+			}
+
+			if err != nil {
+				yield("", fmt.Errorf("Failed to iterate feature cache, %w", err))
+				return
+			}
+
+			if !yield(fc.Id, nil) {
+				return
+			}
+		}
+	}
+}
+
+// notExpiredAfter returns the Unix timestamp after which features are considered not to have expired.
+func (m *DocstoreCacheManager) notExpiredAfter() int64 {
+
+	if m.cache_ttl <= 0 {
+		return -1
+	}
+
+	return time.Now().Add(time.Duration(-m.cache_ttl) * time.Second).Unix()
+}
+
 func (m *DocstoreCacheManager) pruneCaches(ctx context.Context, t time.Time) {
 	go m.pruneFeatureCache(ctx, t)
 }
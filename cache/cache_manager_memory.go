@@ -0,0 +1,481 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default_memory_max_entries is the default maximum number of features stored by a `MemoryCacheManager` instance.
+const default_memory_max_entries int = 1000
+
+func init() {
+
+	ctx := context.Background()
+	err := RegisterCacheManager(ctx, "memory", NewMemoryCacheManager)
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+// MemoryCacheManager implements the `CacheManager` interface storing features in memory, evicting the least
+// recently used features when the number or combined size of the features exceeds a limit. It may optionally wrap
+// another `CacheManager` instance in which case features are written to both and features missing from memory are
+// read from the other cache manager, and stored in memory, if present.
+type MemoryCacheManager struct {
+	statsCounter
+	backing     CacheManager
+	cache_ttl   int
+	max_entries int
+	max_bytes   int64
+	list        *list.List
+	elements    map[string]*list.Element
+	size        int64
+	mu          *sync.Mutex
+}
+
+type MemoryCacheManagerOptions struct {
+	// The number of seconds that features should persist in memory. A value of 0 means features never expire.
+	CacheTTL int
+	// The maximum number of features to store in memory. A value of 0 means there is no limit.
+	MaxEntries int
+	// The maximum combined size, in bytes, of all the features to store in memory. A value of 0 means there is no limit.
+	MaxBytes int64
+	// An optional `CacheManager` instance that features are also written to and read from when missing from memory.
+	Backing CacheManager
+}
+
+type memoryCacheEntry struct {
+	fc    *FeatureCache
+	added int64
+}
+
+func NewMemoryCacheManager(ctx context.Context, uri string) (CacheManager, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	opts := &MemoryCacheManagerOptions{
+		MaxEntries: default_memory_max_entries,
+	}
+
+	if q.Has("ttl") {
+
+		v, err := strconv.Atoi(q.Get("ttl"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?ttl= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?ttl= parameter, must be zero or greater")
+		}
+
+		opts.CacheTTL = v
+	}
+
+	if q.Has("max-entries") {
+
+		v, err := strconv.Atoi(q.Get("max-entries"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max-entries= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?max-entries= parameter, must be zero or greater")
+		}
+
+		opts.MaxEntries = v
+	}
+
+	if q.Has("max-bytes") {
+
+		v, err := strconv.ParseInt(q.Get("max-bytes"), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max-bytes= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?max-bytes= parameter, must be zero or greater")
+		}
+
+		opts.MaxBytes = v
+	}
+
+	if q.Has("backing-cache-uri") {
+
+		backing_uri := q.Get("backing-cache-uri")
+
+		// Features expire from the backing cache after the same TTL as memory unless
+		// the backing cache URI defines its own.
+
+		if opts.CacheTTL > 0 {
+
+			v, err := backingCacheURI(backing_uri, opts.CacheTTL)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to derive backing cache URI, %w", err)
+			}
+
+			backing_uri = v
+		}
+
+		backing, err := NewCacheManager(ctx, backing_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create backing cache manager, %w", err)
+		}
+
+		opts.Backing = backing
+	}
+
+	return NewMemoryCacheManagerWithOptions(ctx, opts), nil
+}
+
+// NewMemoryCacheManagerWithOptions returns a new `MemoryCacheManager` instance for 'opts'.
+func NewMemoryCacheManagerWithOptions(ctx context.Context, opts *MemoryCacheManagerOptions) *MemoryCacheManager {
+
+	m := &MemoryCacheManager{
+		backing:     opts.Backing,
+		cache_ttl:   opts.CacheTTL,
+		max_entries: opts.MaxEntries,
+		max_bytes:   opts.MaxBytes,
+		list:        list.New(),
+		elements:    make(map[string]*list.Element),
+		mu:          new(sync.Mutex),
+	}
+
+	return m
+}
+
+func (m *MemoryCacheManager) CacheFeature(ctx context.Context, body []byte) (*FeatureCache, error) {
+
+	var fc *FeatureCache
+
+	if m.backing != nil {
+
+		v, err := m.backing.CacheFeature(ctx, body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to store feature in backing cache, %w", err)
+		}
+
+		fc = v
+
+	} else {
+
+		v, err := NewFeatureCache(body)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create feature cache, %w", err)
+		}
+
+		fc = v
+	}
+
+	m.addFeatureCache(fc)
+	return fc, nil
+}
+
+func (m *MemoryCacheManager) GetFeatureCache(ctx context.Context, id string) (*FeatureCache, error) {
+
+	fc, exists := m.getFeatureCache(id)
+
+	if exists {
+		m.hit(ctx)
+		return fc, nil
+	}
+
+	if m.backing == nil {
+		m.miss(ctx)
+		return nil, fmt.Errorf("Failed to retrieve feature from cache for %s, not found", id)
+	}
+
+	fc, err := m.backing.GetFeatureCache(ctx, id)
+
+	if err != nil {
+		m.miss(ctx)
+		return nil, err
+	}
+
+	// Features read from the backing cache keep the time they were created so reading
+	// them does not extend how long they persist. Features which have already expired
+	// (because the backing cache defines a longer TTL) are treated as missing.
+
+	if m.isExpired(fc.Created) {
+		m.miss(ctx)
+		return nil, fmt.Errorf("Failed to retrieve feature from cache for %s, expired", id)
+	}
+
+	m.hit(ctx)
+	m.addFeatureCache(fc)
+
+	return fc, nil
+}
+
+// DeleteFeatureCache removes the feature with ID 'id' from memory and from the backing cache, if present.
+func (m *MemoryCacheManager) DeleteFeatureCache(ctx context.Context, id string) error {
+
+	m.mu.Lock()
+
+	el, exists := m.elements[id]
+
+	if exists {
+		m.removeElement(el)
+	}
+
+	m.mu.Unlock()
+
+	if m.backing != nil {
+		return m.backing.DeleteFeatureCache(ctx, id)
+	}
+
+	return nil
+}
+
+// Purge removes all the features from memory and from the backing cache, if present.
+func (m *MemoryCacheManager) Purge(ctx context.Context) error {
+
+	m.mu.Lock()
+
+	m.list.Init()
+	m.elements = make(map[string]*list.Element)
+	m.size = 0
+
+	m.mu.Unlock()
+
+	if m.backing != nil {
+		return m.backing.Purge(ctx)
+	}
+
+	return nil
+}
+
+// Stats returns statistics about the cache. Like `FeatureCacheIds` entries and bytes are the number, and combined
+// size, of the features in the backing cache, if present, or otherwise of the unexpired features stored in memory.
+// Hits include features read from the backing cache.
+func (m *MemoryCacheManager) Stats(ctx context.Context) (*CacheStats, error) {
+
+	if m.backing != nil {
+
+		backing_stats, err := m.backing.Stats(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive stats for backing cache, %w", err)
+		}
+
+		return m.stats(backing_stats.Entries, backing_stats.Bytes), nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.removeExpired()
+
+	return m.stats(int64(m.list.Len()), m.size), nil
+}
+
+// FeatureCacheIds returns an iterator of the IDs of all the features in the backing cache, if present, or
+// otherwise of the features stored in memory ordered from least to most recently used.
+func (m *MemoryCacheManager) FeatureCacheIds(ctx context.Context) iter.Seq2[string, error] {
+
+	if m.backing != nil {
+		return m.backing.FeatureCacheIds(ctx)
+	}
+
+	m.mu.Lock()
+
+	ids := make([]string, 0, m.list.Len())
+
+	for el := m.list.Back(); el != nil; el = el.Prev() {
+
+		e := el.Value.(*memoryCacheEntry)
+
+		if m.isExpired(e.added) {
+			continue
+		}
+
+		ids = append(ids, e.fc.Id)
+	}
+
+	m.mu.Unlock()
+
+	return func(yield func(string, error) bool) {
+
+		for _, id := range ids {
+
+			if !yield(id, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (m *MemoryCacheManager) Close() error {
+
+	m.mu.Lock()
+
+	m.list.Init()
+	m.elements = make(map[string]*list.Element)
+	m.size = 0
+
+	m.mu.Unlock()
+
+	if m.backing != nil {
+		return m.backing.Close()
+	}
+
+	return nil
+}
+
+// getFeatureCache returns a copy of the feature with ID 'id', marking it as the most recently used feature, and a
+// boolean value indicating whether it was found. Expired features are removed.
+func (m *MemoryCacheManager) getFeatureCache(id string) (*FeatureCache, bool) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, exists := m.elements[id]
+
+	if !exists {
+		return nil, false
+	}
+
+	e := el.Value.(*memoryCacheEntry)
+
+	if m.isExpired(e.added) {
+		m.removeElement(el)
+		return nil, false
+	}
+
+	m.list.MoveToFront(el)
+
+	fc := *e.fc
+	return &fc, true
+}
+
+// addFeatureCache stores a copy of 'fc' as the most recently used feature, replacing any existing feature with the
+// same ID, and then evicts the least recently used features until the cache is within its limits. The feature expires
+// relative to the time it was created rather than the time it was stored in memory.
+func (m *MemoryCacheManager) addFeatureCache(fc *FeatureCache) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, exists := m.elements[fc.Id]
+
+	if exists {
+		m.removeElement(el)
+	}
+
+	// Features which would exceed the size limit on their own are not stored in memory
+
+	if m.max_bytes > 0 && int64(len(fc.Body)) > m.max_bytes {
+		return
+	}
+
+	v := *fc
+
+	added := fc.Created
+
+	if added == 0 {
+		added = time.Now().Unix()
+	}
+
+	e := &memoryCacheEntry{
+		fc:    &v,
+		added: added,
+	}
+
+	m.elements[fc.Id] = m.list.PushFront(e)
+	m.size += int64(len(e.fc.Body))
+
+	evicted := 0
+
+	for m.list.Len() > 0 {
+
+		if (m.max_entries == 0 || m.list.Len() <= m.max_entries) && (m.max_bytes == 0 || m.size <= m.max_bytes) {
+			break
+		}
+
+		m.removeElement(m.list.Back())
+		evicted += 1
+	}
+
+	if evicted > 0 {
+		slog.Debug("Evicted features from memory cache", "count", evicted)
+	}
+}
+
+// removeElement removes 'el' from the cache. It is assumed that the caller holds the lock.
+func (m *MemoryCacheManager) removeElement(el *list.Element) {
+
+	e := el.Value.(*memoryCacheEntry)
+
+	m.list.Remove(el)
+	delete(m.elements, e.fc.Id)
+
+	m.size -= int64(len(e.fc.Body))
+}
+
+// removeExpired removes all the expired features from the cache. It is assumed that the caller holds the lock.
+func (m *MemoryCacheManager) removeExpired() {
+
+	if m.cache_ttl == 0 {
+		return
+	}
+
+	for el := m.list.Front(); el != nil; {
+
+		next := el.Next()
+
+		if m.isExpired(el.Value.(*memoryCacheEntry).added) {
+			m.removeElement(el)
+		}
+
+		el = next
+	}
+}
+
+// isExpired reports whether a feature added at 'added' (a Unix timestamp) was added more than the TTL ago.
+func (m *MemoryCacheManager) isExpired(added int64) bool {
+
+	if m.cache_ttl == 0 {
+		return false
+	}
+
+	return time.Now().Unix()-added >= int64(m.cache_ttl)
+}
+
+// backingCacheURI returns 'uri' with its "ttl" query parameter assigned 'ttl' unless it already has one.
+func backingCacheURI(uri string, ttl int) (string, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	q := u.Query()
+
+	if !q.Has("ttl") {
+		q.Set("ttl", strconv.Itoa(ttl))
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheManager(t *testing.T) {
+
+	ctx := context.Background()
+
+	feature := func(id int) []byte {
+		return []byte(fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d},"geometry":null}`, id))
+	}
+
+	ids := func(m CacheManager) []string {
+
+		ids := make([]string, 0)
+
+		for id, err := range m.FeatureCacheIds(ctx) {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate IDs, %v", err)
+			}
+
+			ids = append(ids, id)
+		}
+
+		return ids
+	}
+
+	// Least recently used features are evicted
+
+	cm, err := NewCacheManager(ctx, "memory://?max-entries=2")
+
+	if err != nil {
+		t.Fatalf("Failed to create cache manager, %v", err)
+	}
+
+	defer cm.Close()
+
+	m := cm.(*MemoryCacheManager)
+
+	for _, id := range []int{101, 102} {
+
+		_, err := m.CacheFeature(ctx, feature(id))
+
+		if err != nil {
+			t.Fatalf("Failed to cache feature %d, %v", id, err)
+		}
+	}
+
+	_, err = m.GetFeatureCache(ctx, "101")
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve feature, %v", err)
+	}
+
+	_, err = m.CacheFeature(ctx, feature(103))
+
+	if err != nil {
+		t.Fatalf("Failed to cache feature, %v", err)
+	}
+
+	if !slices.Equal(ids(m), []string{"101", "103"}) {
+		t.Fatalf("Unexpected IDs after eviction, %v", ids(m))
+	}
+
+	// Expired features are not returned
+
+	m.cache_ttl = 60
+	m.elements["101"].Value.(*memoryCacheEntry).added -= 120
+
+	_, err = m.GetFeatureCache(ctx, "101")
+
+	if err == nil {
+		t.Fatalf("Expected expired feature to be treated as missing")
+	}
+
+	if !slices.Equal(ids(m), []string{"103"}) {
+		t.Fatalf("Unexpected IDs after expiry, %v", ids(m))
+	}
+
+	// Expired features are not counted, even if they have not been read
+
+	m.elements["103"].Value.(*memoryCacheEntry).added -= 120
+
+	stats, err := m.Stats(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to derive stats, %v", err)
+	}
+
+	if stats.Entries != 0 || stats.Bytes != 0 {
+		t.Fatalf("Expected expired features not to be counted, %v", stats)
+	}
+
+	// Features exceeding the size limit are not stored
+
+	small_cm, err := NewCacheManager(ctx, "memory://?max-bytes=10")
+
+	if err != nil {
+		t.Fatalf("Failed to create cache manager, %v", err)
+	}
+
+	defer small_cm.Close()
+
+	_, err = small_cm.CacheFeature(ctx, feature(101))
+
+	if err != nil {
+		t.Fatalf("Failed to cache feature, %v", err)
+	}
+
+	if len(ids(small_cm)) != 0 {
+		t.Fatalf("Expected feature exceeding size limit not to be stored")
+	}
+}
+
+func TestMemoryCacheManagerWithBacking(t *testing.T) {
+
+	ctx := context.Background()
+
+	uri := fmt.Sprintf("memory://?max-entries=1&ttl=60&backing-cache-uri=%s", url.QueryEscape("sql://sqlite?dsn={tmp}"))
+
+	cm, err := NewCacheManager(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create cache manager for %s, %v", uri, err)
+	}
+
+	defer cm.Close()
+
+	m := cm.(*MemoryCacheManager)
+
+	for _, id := range []int{101, 102} {
+
+		body := []byte(fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d},"geometry":null}`, id))
+
+		_, err := m.CacheFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to cache feature %d, %v", id, err)
+		}
+	}
+
+	// Features are written through to the backing cache...
+
+	_, exists := m.elements["101"]
+
+	if exists {
+		t.Fatalf("Expected feature to have been evicted from memory")
+	}
+
+	_, err = m.backing.GetFeatureCache(ctx, "101")
+
+	if err != nil {
+		t.Fatalf("Expected feature to be present in backing cache, %v", err)
+	}
+
+	// The TTL is passed to the backing cache and statistics describe the backing cache
+
+	if m.backing.(*SQLCacheManager).cache_ttl != 60 {
+		t.Fatalf("Expected TTL to be passed to backing cache")
+	}
+
+	stats, err := m.Stats(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to derive stats, %v", err)
+	}
+
+	count := 0
+
+	for _, err := range m.FeatureCacheIds(ctx) {
+
+		if err != nil {
+			t.Fatalf("Failed to iterate IDs, %v", err)
+		}
+
+		count += 1
+	}
+
+	if stats.Entries != 2 || count != 2 {
+		t.Fatalf("Expected stats and IDs to describe the backing cache, %v", stats)
+	}
+
+	// ...and read back in to memory when missing
+
+	_, err = m.GetFeatureCache(ctx, "101")
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve feature, %v", err)
+	}
+
+	_, exists = m.elements["101"]
+
+	if !exists {
+		t.Fatalf("Expected feature to have been read back in to memory")
+	}
+
+	err = m.DeleteFeatureCache(ctx, "101")
+
+	if err != nil {
+		t.Fatalf("Failed to delete feature, %v", err)
+	}
+
+	_, err = m.GetFeatureCache(ctx, "101")
+
+	if err == nil {
+		t.Fatalf("Expected deleted feature to be missing")
+	}
+}
+
+func TestMemoryCacheManagerWithExpiredBacking(t *testing.T) {
+
+	ctx := context.Background()
+
+	// The backing cache does not expire features but they should still expire from
+	// memory relative to the time they were created.
+
+	backing := NewMemoryCacheManagerWithOptions(ctx, &MemoryCacheManagerOptions{})
+
+	m := NewMemoryCacheManagerWithOptions(ctx, &MemoryCacheManagerOptions{
+		CacheTTL: 60,
+		Backing:  backing,
+	})
+
+	defer m.Close()
+
+	now := time.Now().Unix()
+
+	backing.addFeatureCache(&FeatureCache{
+		Created: now - 120,
+		Id:      "101",
+		Body:    `{"type":"Feature","properties":{"wof:id":101},"geometry":null}`,
+	})
+
+	backing.addFeatureCache(&FeatureCache{
+		Created: now - 30,
+		Id:      "102",
+		Body:    `{"type":"Feature","properties":{"wof:id":102},"geometry":null}`,
+	})
+
+	_, err := m.GetFeatureCache(ctx, "101")
+
+	if err == nil {
+		t.Fatalf("Expected expired feature to be missing")
+	}
+
+	_, exists := m.elements["101"]
+
+	if exists {
+		t.Fatalf("Expected expired feature not to be read in to memory")
+	}
+
+	fc, err := m.GetFeatureCache(ctx, "102")
+
+	if err != nil {
+		t.Fatalf("Failed to retrieve feature, %v", err)
+	}
+
+	if fc.Created != now-30 {
+		t.Fatalf("Unexpected created time %d, expected %d", fc.Created, now-30)
+	}
+
+	el, exists := m.elements["102"]
+
+	if !exists {
+		t.Fatalf("Expected feature to have been read in to memory")
+	}
+
+	added := el.Value.(*memoryCacheEntry).added
+
+	if added != now-30 {
+		t.Fatalf("Unexpected added time %d, expected %d (the time the feature was created)", added, now-30)
+	}
+}
@@ -4,15 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"iter"
 	"log/slog"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	database_sql "github.com/sfomuseum/go-database/sql"
 	_ "modernc.org/sqlite"
 )
 
+// default_sql_prune_interval is the default number of seconds between attempts to remove expired features, and
+// enforce size limits, in the background.
+const default_sql_prune_interval int = 60
+
 func init() {
 
 	ctx := context.Background()
@@ -34,14 +42,38 @@ func (t *SQLFeaturesTable) Name() string {
 func (t *SQLFeaturesTable) Schema(db *sql.DB) (string, error) {
 	switch database_sql.Driver(db) {
 	case database_sql.SQLITE_DRIVER:
-		return "CREATE TABLE features (id TEXT PRIMARY KEY, body TEXT)", nil
+		return "CREATE TABLE features (id TEXT PRIMARY KEY, body TEXT, created INTEGER NOT NULL DEFAULT 0)", nil
 	default:
 		return "", fmt.Errorf("Unsupported database driver %s", database_sql.Driver(db))
 	}
 }
 
+// InitializeTable creates the features table, if necessary, and the index used to find expired features.
 func (t *SQLFeaturesTable) InitializeTable(ctx context.Context, db *sql.DB) error {
-	return database_sql.CreateTableIfNecessary(ctx, db, t)
+
+	err := database_sql.CreateTableIfNecessary(ctx, db, t)
+
+	if err != nil {
+		return err
+	}
+
+	switch database_sql.Driver(db) {
+	case database_sql.SQLITE_DRIVER:
+
+		err := ensureCreatedColumn(ctx, db)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS features_by_created ON features (created)")
+
+	if err != nil {
+		return fmt.Errorf("Failed to create index, %w", err)
+	}
+
+	return nil
 }
 
 func (t *SQLFeaturesTable) IndexRecord(ctx context.Context, db *sql.DB, i interface{}) error {
@@ -49,13 +81,30 @@ func (t *SQLFeaturesTable) IndexRecord(ctx context.Context, db *sql.DB, i interf
 }
 
 type SQLCacheManager struct {
+	statsCounter
 	feature_collection *sql.DB
 	is_tmp             bool
 	tmp_path           string
+	cache_ttl          int
+	max_entries        int
+	max_bytes          int64
+	ticker             *time.Ticker
+	done               chan bool
+	wg                 *sync.WaitGroup
+	close_once         *sync.Once
 }
 
 type SQLCacheManagerOptions struct {
 	FeatureCollection *sql.DB
+	// The number of seconds that features should persist. A value of 0 means features never expire.
+	CacheTTL int
+	// The maximum number of features to store. A value of 0 means there is no limit.
+	MaxEntries int
+	// The maximum combined size, in bytes, of all the features to store. A value of 0 means there is no limit.
+	MaxBytes int64
+	// The number of seconds between attempts to remove expired features, and enforce size limits, in the
+	// background. A value of 0 disables pruning in the background.
+	PruneInterval int
 }
 
 func NewSQLCacheManager(ctx context.Context, uri string) (CacheManager, error) {
@@ -71,6 +120,71 @@ func NewSQLCacheManager(ctx context.Context, uri string) (CacheManager, error) {
 
 	dsn := q.Get("dsn")
 
+	ttl := 0
+	max_entries := 0
+	max_bytes := int64(0)
+	prune_interval := default_sql_prune_interval
+
+	if q.Has("ttl") {
+
+		v, err := strconv.Atoi(q.Get("ttl"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?ttl= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?ttl= parameter, must be zero or greater")
+		}
+
+		ttl = v
+	}
+
+	if q.Has("max-entries") {
+
+		v, err := strconv.Atoi(q.Get("max-entries"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max-entries= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?max-entries= parameter, must be zero or greater")
+		}
+
+		max_entries = v
+	}
+
+	if q.Has("max-bytes") {
+
+		v, err := strconv.ParseInt(q.Get("max-bytes"), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max-bytes= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?max-bytes= parameter, must be zero or greater")
+		}
+
+		max_bytes = v
+	}
+
+	if q.Has("prune-interval") {
+
+		v, err := strconv.Atoi(q.Get("prune-interval"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?prune-interval= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?prune-interval= parameter, must be zero or greater")
+		}
+
+		prune_interval = v
+	}
+
 	is_tmp := false
 	tmp_path := ""
 
@@ -98,6 +212,21 @@ func NewSQLCacheManager(ctx context.Context, uri string) (CacheManager, error) {
 		return nil, fmt.Errorf("Failed to open database connection, %w", err)
 	}
 
+	switch engine {
+	case "sqlite", "sqlite3":
+
+		conn.SetMaxOpenConns(1)
+
+		// Allow the space freed by pruning features to be reclaimed. This is
+		// ignored by databases which already contain tables.
+
+		_, err = conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL")
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to set auto_vacuum pragma, %w", err)
+		}
+	}
+
 	features_table := new(SQLFeaturesTable)
 
 	db_opts := database_sql.DefaultConfigureDatabaseOptions()
@@ -114,21 +243,84 @@ func NewSQLCacheManager(ctx context.Context, uri string) (CacheManager, error) {
 		return nil, fmt.Errorf("Failed to configure database, %w", err)
 	}
 
-	switch engine {
-	case "sqlite", "sqlite3":
+	err = features_table.InitializeTable(ctx, conn)
 
-		conn.SetMaxOpenConns(1)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to initialize features table, %w", err)
 	}
 
-	m := &SQLCacheManager{
-		feature_collection: conn,
-		is_tmp:             is_tmp,
-		tmp_path:           tmp_path,
+	opts := &SQLCacheManagerOptions{
+		FeatureCollection: conn,
+		CacheTTL:          ttl,
+		MaxEntries:        max_entries,
+		MaxBytes:          max_bytes,
+		PruneInterval:     prune_interval,
 	}
 
+	m := NewSQLCacheManagerWithOptions(ctx, opts)
+
+	m.is_tmp = is_tmp
+	m.tmp_path = tmp_path
+
 	return m, nil
 }
 
+// NewSQLCacheManagerWithOptions returns a new `SQLCacheManager` instance for 'opts'. It is assumed that the features
+// table has already been created. If 'opts' defines a TTL or size limits then features are pruned once at startup
+// and then every 'opts.PruneInterval' seconds until the Close method is invoked.
+func NewSQLCacheManagerWithOptions(ctx context.Context, opts *SQLCacheManagerOptions) *SQLCacheManager {
+
+	m := &SQLCacheManager{
+		feature_collection: opts.FeatureCollection,
+		cache_ttl:          opts.CacheTTL,
+		max_entries:        opts.MaxEntries,
+		max_bytes:          opts.MaxBytes,
+		done:               make(chan bool),
+		wg:                 new(sync.WaitGroup),
+		close_once:         new(sync.Once),
+	}
+
+	if m.cache_ttl == 0 && m.max_entries == 0 && m.max_bytes == 0 {
+		return m
+	}
+
+	err := m.Prune(ctx)
+
+	if err != nil {
+		slog.Error("Failed to prune feature cache", "error", err)
+	}
+
+	if opts.PruneInterval == 0 {
+		return m
+	}
+
+	ticker := time.NewTicker(time.Duration(opts.PruneInterval) * time.Second)
+	m.ticker = ticker
+
+	m.wg.Add(1)
+
+	go func() {
+
+		defer m.wg.Done()
+
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+
+				err := m.Prune(ctx)
+
+				if err != nil {
+					slog.Error("Failed to prune feature cache", "error", err)
+				}
+			}
+		}
+	}()
+
+	return m
+}
+
 func (m *SQLCacheManager) CacheFeature(ctx context.Context, body []byte) (*FeatureCache, error) {
 
 	if m.feature_collection == nil {
@@ -141,9 +333,9 @@ func (m *SQLCacheManager) CacheFeature(ctx context.Context, body []byte) (*Featu
 		return nil, fmt.Errorf("Failed to create feature cache, %w", err)
 	}
 
-	q := "INSERT OR REPLACE INTO features (id, body) VALUES (?,?)"
+	q := "INSERT OR REPLACE INTO features (id, body, created) VALUES (?,?,?)"
 
-	_, err = m.feature_collection.ExecContext(ctx, q, fc.Id, fc.Body)
+	_, err = m.feature_collection.ExecContext(ctx, q, fc.Id, fc.Body, fc.Created)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to store feature cache for %s, %w", fc.Id, err)
@@ -166,15 +358,16 @@ func (m *SQLCacheManager) GetFeatureCache(ctx context.Context, id string) (*Feat
 	}
 
 	var body string
+	var created int64
 
-	q := "SELECT body FROM features WHERE id=?"
+	q := "SELECT body, created FROM features WHERE id=?"
 
 	row := m.feature_collection.QueryRowContext(ctx, q, id)
-	err := row.Scan(&body)
+	err := row.Scan(&body, &created)
 
 	switch {
 	case err == sql.ErrNoRows:
-		slog.Error("POO", "id", id, "error", err)
+		m.miss(ctx)
 		return nil, fmt.Errorf("Failed to retrieve feature, %w", err)
 	case err != nil:
 		return nil, fmt.Errorf("Failed to query ID, %w", err)
@@ -182,24 +375,356 @@ func (m *SQLCacheManager) GetFeatureCache(ctx context.Context, id string) (*Feat
 		//
 	}
 
+	// Features which have expired but not been pruned yet are treated as missing
+
+	if m.cache_ttl > 0 && created <= m.expires().Unix() {
+		status = "EXPIRED"
+		m.miss(ctx)
+		return nil, fmt.Errorf("Failed to retrieve feature, %w", sql.ErrNoRows)
+	}
+
 	status = "HIT"
+	m.hit(ctx)
 
 	fc := FeatureCache{
-		Id:   id,
-		Body: body,
+		Created: created,
+		Id:      id,
+		Body:    body,
 	}
 
 	return &fc, nil
 }
 
+// Prune removes expired features and then, if the number or combined size of the remaining features exceeds the
+// limits defined when 'm' was created, removes the oldest features until both limits are satisfied.
+func (m *SQLCacheManager) Prune(ctx context.Context) error {
+
+	if m.feature_collection == nil {
+		return nil
+	}
+
+	if m.cache_ttl > 0 {
+
+		then := m.expires()
+
+		slog.Debug("Prune feature cache", "older than", then)
+
+		_, err := m.feature_collection.ExecContext(ctx, "DELETE FROM features WHERE created <= ?", then.Unix())
+
+		if err != nil {
+			return fmt.Errorf("Failed to remove expired features, %w", err)
+		}
+	}
+
+	if m.max_entries > 0 || m.max_bytes > 0 {
+
+		err := m.evictFeatures(ctx)
+
+		if err != nil {
+			return fmt.Errorf("Failed to evict features, %w", err)
+		}
+	}
+
+	return m.vacuum(ctx)
+}
+
+// evictFeatures removes the oldest features until the number and combined size of the remaining features are
+// within the limits defined when 'm' was created.
+func (m *SQLCacheManager) evictFeatures(ctx context.Context) error {
+
+	count, size, err := m.countFeatures(ctx, false)
+
+	if err != nil {
+		return err
+	}
+
+	over_entries := m.max_entries > 0 && count > int64(m.max_entries)
+	over_bytes := m.max_bytes > 0 && size > m.max_bytes
+
+	if !over_entries && !over_bytes {
+		return nil
+	}
+
+	// Gather the IDs to remove before removing them since SQLite databases are limited
+	// to a single connection (see above).
+
+	rows, err := m.feature_collection.QueryContext(ctx, "SELECT id, LENGTH(CAST(body AS BLOB)) FROM features ORDER BY created ASC, id ASC")
+
+	if err != nil {
+		return fmt.Errorf("Failed to query features, %w", err)
+	}
+
+	defer rows.Close()
+
+	to_remove := make([]string, 0)
+
+	for rows.Next() {
+
+		if (m.max_entries == 0 || count <= int64(m.max_entries)) && (m.max_bytes == 0 || size <= m.max_bytes) {
+			break
+		}
+
+		var id string
+		var length int64
+
+		err := rows.Scan(&id, &length)
+
+		if err != nil {
+			return fmt.Errorf("Failed to scan feature, %w", err)
+		}
+
+		to_remove = append(to_remove, id)
+
+		count -= 1
+		size -= length
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return fmt.Errorf("Failed to iterate features, %w", err)
+	}
+
+	rows.Close()
+
+	tx, err := m.feature_collection.BeginTx(ctx, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create transaction, %w", err)
+	}
+
+	for _, id := range to_remove {
+
+		_, err := tx.ExecContext(ctx, "DELETE FROM features WHERE id=?", id)
+
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Failed to remove %s, %w", id, err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	slog.Debug("Evicted features from feature cache", "count", len(to_remove))
+	return nil
+}
+
+// DeleteFeatureCache removes the feature with ID 'id' from the cache.
+func (m *SQLCacheManager) DeleteFeatureCache(ctx context.Context, id string) error {
+
+	if m.feature_collection == nil {
+		return fmt.Errorf("No feature collection defined")
+	}
+
+	_, err := m.feature_collection.ExecContext(ctx, "DELETE FROM features WHERE id=?", id)
+
+	if err != nil {
+		return fmt.Errorf("Failed to remove %s from feature cache, %w", id, err)
+	}
+
+	return nil
+}
+
+// Purge removes all the features from the cache.
+func (m *SQLCacheManager) Purge(ctx context.Context) error {
+
+	if m.feature_collection == nil {
+		return fmt.Errorf("No feature collection defined")
+	}
+
+	_, err := m.feature_collection.ExecContext(ctx, "DELETE FROM features")
+
+	if err != nil {
+		return fmt.Errorf("Failed to purge feature cache, %w", err)
+	}
+
+	return m.vacuum(ctx)
+}
+
+// Stats returns statistics about the cache. Features which have expired but have not been pruned yet are not counted.
+func (m *SQLCacheManager) Stats(ctx context.Context) (*CacheStats, error) {
+
+	if m.feature_collection == nil {
+		return nil, fmt.Errorf("No feature collection defined")
+	}
+
+	count, size, err := m.countFeatures(ctx, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.stats(count, size), nil
+}
+
+// FeatureCacheIds returns an iterator of the IDs of all the features in the cache, oldest first. The IDs are
+// read before any are yielded so that the cache can be queried (or modified) while iterating.
+func (m *SQLCacheManager) FeatureCacheIds(ctx context.Context) iter.Seq2[string, error] {
+
+	return func(yield func(string, error) bool) {
+
+		if m.feature_collection == nil {
+			yield("", fmt.Errorf("No feature collection defined"))
+			return
+		}
+
+		ids, err := m.featureIds(ctx)
+
+		if err != nil {
+			yield("", err)
+			return
+		}
+
+		for _, id := range ids {
+
+			if !yield(id, nil) {
+				return
+			}
+		}
+	}
+}
+
+// featureIds returns the IDs of all the features in the cache, excluding features which have expired, oldest first.
+func (m *SQLCacheManager) featureIds(ctx context.Context) ([]string, error) {
+
+	q := "SELECT id FROM features WHERE created > ? ORDER BY created ASC, id ASC"
+
+	rows, err := m.feature_collection.QueryContext(ctx, q, m.notExpiredAfter())
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query features, %w", err)
+	}
+
+	defer rows.Close()
+
+	ids := make([]string, 0)
+
+	for rows.Next() {
+
+		var id string
+		err := rows.Scan(&id)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan feature, %w", err)
+		}
+
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate features, %w", err)
+	}
+
+	return ids, nil
+}
+
+// countFeatures returns the number, and combined size in bytes, of the features in the cache. If 'exclude_expired'
+// is true then features which have expired but have not been pruned yet are not counted.
+func (m *SQLCacheManager) countFeatures(ctx context.Context, exclude_expired bool) (int64, int64, error) {
+
+	var count int64
+	var size int64
+
+	created := int64(-1)
+
+	if exclude_expired {
+		created = m.notExpiredAfter()
+	}
+
+	q := "SELECT COUNT(id), COALESCE(SUM(LENGTH(CAST(body AS BLOB))), 0) FROM features WHERE created > ?"
+
+	row := m.feature_collection.QueryRowContext(ctx, q, created)
+	err := row.Scan(&count, &size)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to count features, %w", err)
+	}
+
+	return count, size, nil
+}
+
+// notExpiredAfter returns the Unix timestamp after which features are considered not to have expired.
+func (m *SQLCacheManager) notExpiredAfter() int64 {
+
+	if m.cache_ttl == 0 {
+		return -1
+	}
+
+	return m.expires().Unix()
+}
+
+// vacuum reclaims the space freed by removing features, if supported by the database.
+func (m *SQLCacheManager) vacuum(ctx context.Context) error {
+
+	switch database_sql.Driver(m.feature_collection) {
+	case database_sql.SQLITE_DRIVER:
+
+		_, err := m.feature_collection.ExecContext(ctx, "PRAGMA incremental_vacuum")
+
+		if err != nil {
+			return fmt.Errorf("Failed to vacuum database, %w", err)
+		}
+	}
+
+	return nil
+}
+
+// expires returns the time at, or before, which features are considered to have expired.
+func (m *SQLCacheManager) expires() time.Time {
+	return time.Now().Add(time.Duration(-m.cache_ttl) * time.Second)
+}
+
+// Close stops pruning features in the background and closes the underlying database. It is safe to call
+// Close more than once.
 func (m *SQLCacheManager) Close() error {
 
-	if m.feature_collection != nil {
-		m.feature_collection.Close()
+	m.close_once.Do(func() {
+
+		if m.ticker != nil {
+			m.ticker.Stop()
+		}
+
+		close(m.done)
+		m.wg.Wait()
+
+		if m.feature_collection != nil {
+			m.feature_collection.Close()
+		}
+
+		if m.is_tmp {
+			os.Remove(m.tmp_path)
+		}
+	})
+
+	return nil
+}
+
+// ensureCreatedColumn adds the "created" column to SQLite features tables created before the column existed.
+func ensureCreatedColumn(ctx context.Context, conn *sql.DB) error {
+
+	var count int
+
+	row := conn.QueryRowContext(ctx, "SELECT COUNT(name) FROM pragma_table_info('features') WHERE name='created'")
+	err := row.Scan(&count)
+
+	if err != nil {
+		return fmt.Errorf("Failed to inspect features table, %w", err)
 	}
 
-	if m.is_tmp {
-		os.Remove(m.tmp_path)
+	if count > 0 {
+		return nil
+	}
+
+	_, err = conn.ExecContext(ctx, "ALTER TABLE features ADD COLUMN created INTEGER NOT NULL DEFAULT 0")
+
+	if err != nil {
+		return fmt.Errorf("Failed to add created column, %w", err)
 	}
 
 	return nil
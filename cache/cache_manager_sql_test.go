@@ -0,0 +1,155 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSQLCacheManagerPrune(t *testing.T) {
+
+	ctx := context.Background()
+
+	feature := func(id int) []byte {
+		return []byte(fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d},"geometry":null}`, id))
+	}
+
+	tests := map[string][]string{
+		"sql://sqlite?dsn={tmp}&ttl=60&prune-interval=0":          []string{"102", "103"},
+		"sql://sqlite?dsn={tmp}&max-entries=2&prune-interval=0":   []string{"102", "103"},
+		"sql://sqlite?dsn={tmp}&max-entries=1&prune-interval=0":   []string{"103"},
+		"sql://sqlite?dsn={tmp}&max-bytes=130&prune-interval=0":   []string{"102", "103"},
+		"sql://sqlite?dsn={tmp}&ttl=60&max-entries=1&max-bytes=1": []string{},
+	}
+
+	for uri, expected := range tests {
+
+		cm, err := NewCacheManager(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create cache manager for %s, %v", uri, err)
+		}
+
+		m := cm.(*SQLCacheManager)
+
+		now := time.Now()
+
+		for i, id := range []int{101, 102, 103} {
+
+			_, err := m.CacheFeature(ctx, feature(id))
+
+			if err != nil {
+				t.Fatalf("Failed to cache feature %d for %s, %v", id, uri, err)
+			}
+
+			// Make the first feature older than the TTL and the others progressively newer
+
+			created := now.Add(time.Duration(i) * time.Second)
+
+			if i == 0 {
+				created = now.Add(-120 * time.Second)
+			}
+
+			_, err = m.feature_collection.ExecContext(ctx, "UPDATE features SET created=? WHERE id=?", created.Unix(), fmt.Sprintf("%d", id))
+
+			if err != nil {
+				t.Fatalf("Failed to update feature %d for %s, %v", id, uri, err)
+			}
+		}
+
+		if m.cache_ttl > 0 {
+
+			_, err := m.GetFeatureCache(ctx, "101")
+
+			if err == nil {
+				t.Fatalf("Expected expired feature to be treated as missing for %s", uri)
+			}
+		}
+
+		err = m.Prune(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to prune features for %s, %v", uri, err)
+		}
+
+		var count int
+
+		row := m.feature_collection.QueryRowContext(ctx, "SELECT COUNT(id) FROM features")
+		err = row.Scan(&count)
+
+		if err != nil {
+			t.Fatalf("Failed to count features for %s, %v", uri, err)
+		}
+
+		if count != len(expected) {
+			t.Fatalf("Unexpected feature count for %s (%d), expected %d", uri, count, len(expected))
+		}
+
+		for _, id := range expected {
+
+			_, err := m.GetFeatureCache(ctx, id)
+
+			if err != nil {
+				t.Fatalf("Expected feature %s to remain for %s, %v", id, uri, err)
+			}
+		}
+
+		err = m.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to close cache manager for %s, %v", uri, err)
+		}
+	}
+}
+
+func TestSQLCacheManagerClose(t *testing.T) {
+
+	ctx := context.Background()
+
+	uri := "sql://sqlite?dsn={tmp}&ttl=1&prune-interval=1"
+
+	cm, err := NewCacheManager(ctx, uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create cache manager for %s, %v", uri, err)
+	}
+
+	m := cm.(*SQLCacheManager)
+
+	if m.ticker == nil {
+		t.Fatalf("Expected features to be pruned in the background")
+	}
+
+	done_ch := make(chan bool)
+
+	// Closing the cache manager more than once should not block
+
+	go func() {
+		m.Close()
+		m.Close()
+		done_ch <- true
+	}()
+
+	select {
+	case <-done_ch:
+		// pass
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for cache manager to close")
+	}
+
+	invalid := []string{
+		"sql://sqlite?dsn={tmp}&ttl=-1",
+		"sql://sqlite?dsn={tmp}&max-entries=two",
+		"sql://sqlite?dsn={tmp}&max-bytes=-1",
+	}
+
+	for _, uri := range invalid {
+
+		_, err := NewCacheManager(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to be invalid", uri)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestCacheManagerAdministration(t *testing.T) {
+
+	ctx := context.Background()
+
+	uris := []string{
+		"sql://sqlite?dsn={tmp}",
+		"mem://pmtiles_features/Id",
+		"memory://",
+		fmt.Sprintf("blob://?bucket-uri=%s", url.QueryEscape("file://"+t.TempDir())),
+	}
+
+	for _, uri := range uris {
+
+		m, err := NewCacheManager(ctx, uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create cache manager for %s, %v", uri, err)
+		}
+
+		defer m.Close()
+
+		bytes := int64(0)
+
+		for _, id := range []int{101, 102, 103} {
+
+			body := []byte(fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d},"geometry":null}`, id))
+			bytes += int64(len(body))
+
+			_, err := m.CacheFeature(ctx, body)
+
+			if err != nil {
+				t.Fatalf("Failed to cache feature %d for %s, %v", id, uri, err)
+			}
+		}
+
+		_, err = m.GetFeatureCache(ctx, "101")
+
+		if err != nil {
+			t.Fatalf("Failed to retrieve feature for %s, %v", uri, err)
+		}
+
+		_, err = m.GetFeatureCache(ctx, "999")
+
+		if err == nil {
+			t.Fatalf("Expected missing feature to fail for %s", uri)
+		}
+
+		// Lookups which are not counted
+
+		_, err = m.GetFeatureCache(WithoutStats(ctx), "101")
+
+		if err != nil {
+			t.Fatalf("Failed to retrieve feature without stats for %s, %v", uri, err)
+		}
+
+		_, err = m.GetFeatureCache(WithoutStats(ctx), "999")
+
+		if err == nil {
+			t.Fatalf("Expected missing feature to fail for %s", uri)
+		}
+
+		stats, err := m.Stats(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to derive stats for %s, %v", uri, err)
+		}
+
+		if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 3 || stats.Bytes != bytes {
+			t.Fatalf("Unexpected stats for %s: %v", uri, stats)
+		}
+
+		err = m.DeleteFeatureCache(ctx, "102")
+
+		if err != nil {
+			t.Fatalf("Failed to delete feature for %s, %v", uri, err)
+		}
+
+		// Deleting a feature which is not present is not an error
+
+		err = m.DeleteFeatureCache(ctx, "999")
+
+		if err != nil {
+			t.Fatalf("Failed to delete missing feature for %s, %v", uri, err)
+		}
+
+		ids := make([]string, 0)
+
+		for id, err := range m.FeatureCacheIds(ctx) {
+
+			if err != nil {
+				t.Fatalf("Failed to iterate IDs for %s, %v", uri, err)
+			}
+
+			ids = append(ids, id)
+		}
+
+		slices.Sort(ids)
+
+		if !slices.Equal(ids, []string{"101", "103"}) {
+			t.Fatalf("Unexpected IDs for %s: %v", uri, ids)
+		}
+
+		err = m.Purge(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to purge cache for %s, %v", uri, err)
+		}
+
+		stats, err = m.Stats(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to derive stats for %s, %v", uri, err)
+		}
+
+		if stats.Entries != 0 || stats.Bytes != 0 {
+			t.Fatalf("Expected cache to be empty after purging for %s: %v", uri, stats)
+		}
+	}
+}
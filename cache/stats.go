@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type withoutStatsKey struct{}
+
+// WithoutStats returns a copy of 'ctx' which signals to `CacheManager` implementations that features retrieved
+// using it should not be counted as hits or misses. It is meant for internal lookups, for example while assembling
+// features, which would otherwise inflate the statistics reported by the `Stats` method.
+func WithoutStats(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutStatsKey{}, true)
+}
+
+// countStats reports whether hits and misses should be counted for 'ctx'.
+func countStats(ctx context.Context) bool {
+	v, ok := ctx.Value(withoutStatsKey{}).(bool)
+	return !(ok && v)
+}
+
+// CacheStats contains statistics about the features stored by a `CacheManager` instance.
+type CacheStats struct {
+	// The number of features successfully retrieved from the cache.
+	Hits int64 `json:"hits"`
+	// The number of features which were not present in, or had expired from, the cache.
+	Misses int64 `json:"misses"`
+	// The number of features in the cache.
+	Entries int64 `json:"entries"`
+	// The combined size, in bytes, of the bodies of all the features in the cache.
+	Bytes int64 `json:"bytes"`
+}
+
+// statsCounter counts cache hits and misses. It is meant to be embedded by `CacheManager` implementations.
+type statsCounter struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+func (c *statsCounter) hit(ctx context.Context) {
+
+	if countStats(ctx) {
+		c.hits.Add(1)
+	}
+}
+
+func (c *statsCounter) miss(ctx context.Context) {
+
+	if countStats(ctx) {
+		c.misses.Add(1)
+	}
+}
+
+// stats returns a new `CacheStats` instance for 'entries' and 'bytes' and the hits and misses counted by 'c'.
+func (c *statsCounter) stats(entries int64, bytes int64) *CacheStats {
+
+	s := &CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: entries,
+		Bytes:   bytes,
+	}
+
+	return s
+}
@@ -0,0 +1,137 @@
+package main
+
+// go run cmd/feature-cache/main.go -feature-cache-uri 'sql://sqlite?dsn=/tmp/features.db' stats | jq
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	_ "gocloud.dev/blob/s3blob"
+	_ "gocloud.dev/docstore/awsdynamodb"
+	_ "gocloud.dev/docstore/memdocstore"
+
+	"github.com/whosonfirst/go-whosonfirst-spatial-pmtiles/cache"
+)
+
+func main() {
+
+	var feature_cache_uri string
+
+	flag.StringVar(&feature_cache_uri, "feature-cache-uri", "", "A valid whosonfirst/go-whosonfirst-spatial-pmtiles/cache.CacheManager URI.")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Inspect or modify the features stored in a feature cache.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options] stats|ids|delete|purge [id id...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Valid options are:\n")
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	args := flag.Args()
+
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	u, err := url.Parse(feature_cache_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to parse feature cache URI, %v", err)
+	}
+
+	// Features in a memory:// cache only exist in the process using it
+
+	if u.Scheme == "memory" && !u.Query().Has("backing-cache-uri") {
+		log.Fatalf("memory:// feature caches without a backing cache can not be inspected outside the process using them")
+	}
+
+	ctx := context.Background()
+
+	m, err := cache.NewCacheManager(ctx, feature_cache_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create cache manager, %v", err)
+	}
+
+	// Close the cache manager explicitly, rather than deferring it, since log.Fatal
+	// exits without running deferred functions.
+
+	err = run(ctx, m, args)
+
+	m.Close()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(ctx context.Context, m cache.CacheManager, args []string) error {
+
+	switch args[0] {
+	case "stats":
+
+		stats, err := m.Stats(ctx)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive stats, %w", err)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		err = enc.Encode(stats)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode stats, %w", err)
+		}
+
+		// Hits and misses are counted by each cache manager instance so they are only
+		// those of this process, which never reads from the cache.
+
+		fmt.Fprintf(os.Stderr, "Hits and misses are counted per process and are not those of other processes using this cache. Use the ?feature-cache-admin-address= parameter of a running database to report its hits and misses.\n")
+
+	case "ids":
+
+		for id, err := range m.FeatureCacheIds(ctx) {
+
+			if err != nil {
+				return fmt.Errorf("Failed to iterate feature cache, %w", err)
+			}
+
+			fmt.Println(id)
+		}
+
+	case "delete":
+
+		if len(args) < 2 {
+			return fmt.Errorf("Missing feature IDs to delete. Usage: delete id [id...]")
+		}
+
+		for _, id := range args[1:] {
+
+			err := m.DeleteFeatureCache(ctx, id)
+
+			if err != nil {
+				return fmt.Errorf("Failed to delete %s, %w", id, err)
+			}
+		}
+
+	case "purge":
+
+		err := m.Purge(ctx)
+
+		if err != nil {
+			return fmt.Errorf("Failed to purge feature cache, %w", err)
+		}
+
+	default:
+		return fmt.Errorf("Invalid command '%s'", args[0])
+	}
+
+	return nil
+}
@@ -15,9 +15,9 @@ import (
 	_ "gocloud.dev/blob/s3blob"
 	_ "gocloud.dev/docstore/memdocstore"
 
-	"github.com/paulmach/orb/encoding/mvt"
 	"github.com/paulmach/orb/maptile"
 	"github.com/protomaps/go-pmtiles/pmtiles"
+	sp_pmtiles "github.com/whosonfirst/go-whosonfirst-spatial-pmtiles"
 )
 
 func main() {
@@ -56,16 +56,21 @@ func main() {
 
 	path := fmt.Sprintf("/%s/%d/%d/%d.mvt", database, z, x, y)
 
-	status_code, _, body := server.Get(ctx, path)
+	status_code, headers, body := server.Get(ctx, path)
 
 	if status_code != 200 {
 		log.Fatalf("%s returns status code %d\n", path, status_code)
 	}
 
-	layers, err := mvt.UnmarshalGzipped(body)
+	// The pmtiles.Server returns the tile data as-is along with a Content-Encoding
+	// header derived from the tile compression declared in the database header.
+
+	compression := sp_pmtiles.CompressionFromContentEncoding(headers["Content-Encoding"])
+
+	layers, err := sp_pmtiles.UnmarshalTile(ctx, compression, body)
 
 	if err != nil {
-		log.Fatalf("Failed to unmarshal gzipped body for %s, %v", path, err)
+		log.Fatalf("Failed to unmarshal body for %s, %v", path, err)
 	}
 
 	layers.ProjectToWGS84(t)
@@ -0,0 +1,191 @@
+package pmtiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/protomaps/go-pmtiles/pmtiles"
+)
+
+// TileDecompressorFunc is a function used to decompress the (raw) data for a tile read from a PMTiles database.
+type TileDecompressorFunc func(ctx context.Context, body []byte) ([]byte, error)
+
+var tile_decompressors = make(map[pmtiles.Compression]TileDecompressorFunc)
+
+var tile_decompressors_mutex = new(sync.RWMutex)
+
+func init() {
+
+	ctx := context.Background()
+
+	builtin := map[pmtiles.Compression]TileDecompressorFunc{
+		pmtiles.NoCompression: noDecompressor,
+		pmtiles.Gzip:          gzipDecompressor,
+	}
+
+	for compression, decompressor := range builtin {
+
+		err := RegisterTileDecompressor(ctx, compression, decompressor)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterTileDecompressor registers 'decompressor' as the function used to decompress tiles in PMTiles databases
+// whose header declares their tile compression as 'compression'. Decompressors for uncompressed and gzip-compressed
+// tiles are registered by default. Decompressors for brotli or zstd compressed tiles are not, in order to avoid
+// additional dependencies, and need to be registered by applications reading databases using those codecs.
+func RegisterTileDecompressor(ctx context.Context, compression pmtiles.Compression, decompressor TileDecompressorFunc) error {
+
+	if decompressor == nil {
+		return fmt.Errorf("Missing decompressor for %s", compressionName(compression))
+	}
+
+	if compression == pmtiles.UnknownCompression {
+		return fmt.Errorf("Decompressors can not be registered for unknown compression")
+	}
+
+	tile_decompressors_mutex.Lock()
+	defer tile_decompressors_mutex.Unlock()
+
+	_, exists := tile_decompressors[compression]
+
+	if exists {
+		return fmt.Errorf("Tile decompressor for %s has already been registered", compressionName(compression))
+	}
+
+	tile_decompressors[compression] = decompressor
+	return nil
+}
+
+// DecompressTile decompresses 'body' using the decompressor registered for 'compression'. If 'compression' is
+// unknown, as is the case for some older databases, then 'body' is assumed to be gzip-compressed if it starts
+// with the gzip magic number and uncompressed otherwise.
+func DecompressTile(ctx context.Context, compression pmtiles.Compression, body []byte) ([]byte, error) {
+
+	if compression == pmtiles.UnknownCompression {
+
+		if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+			compression = pmtiles.Gzip
+		} else {
+			compression = pmtiles.NoCompression
+		}
+	}
+
+	decompressor, err := tileDecompressor(compression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := decompressor(ctx, body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decompress %s tile, %w", compressionName(compression), err)
+	}
+
+	return decompressed, nil
+}
+
+// UnmarshalTile decompresses 'body' using the decompressor registered for 'compression' and returns the MVT layers
+// it contains.
+func UnmarshalTile(ctx context.Context, compression pmtiles.Compression, body []byte) (mvt.Layers, error) {
+
+	decompressed, err := DecompressTile(ctx, compression, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	layers, err := mvt.Unmarshal(decompressed)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal tile, %w", err)
+	}
+
+	return layers, nil
+}
+
+// CompressionFromContentEncoding returns the compression corresponding to the value of the "Content-Encoding"
+// header returned, alongside a tile, by the `pmtiles.Server.Get` method. An empty value (which is returned for both
+// uncompressed tiles and tiles with an unknown compression) yields `pmtiles.UnknownCompression`.
+func CompressionFromContentEncoding(enc string) pmtiles.Compression {
+
+	switch enc {
+	case "gzip":
+		return pmtiles.Gzip
+	case "br":
+		return pmtiles.Brotli
+	case "zstd":
+		return pmtiles.Zstd
+	default:
+		return pmtiles.UnknownCompression
+	}
+}
+
+// ensureTileDecompressor returns an error if there is no decompressor registered for 'compression'.
+func ensureTileDecompressor(compression pmtiles.Compression) error {
+
+	if compression == pmtiles.UnknownCompression {
+		return nil
+	}
+
+	_, err := tileDecompressor(compression)
+	return err
+}
+
+// tileDecompressor returns the decompressor registered for 'compression'.
+func tileDecompressor(compression pmtiles.Compression) (TileDecompressorFunc, error) {
+
+	tile_decompressors_mutex.RLock()
+	defer tile_decompressors_mutex.RUnlock()
+
+	decompressor, exists := tile_decompressors[compression]
+
+	if !exists {
+		return nil, fmt.Errorf("Unsupported tile compression '%s', use RegisterTileDecompressor to add support for it", compressionName(compression))
+	}
+
+	return decompressor, nil
+}
+
+// compressionName returns a human-readable name for 'compression'.
+func compressionName(compression pmtiles.Compression) string {
+
+	switch compression {
+	case pmtiles.NoCompression:
+		return "none"
+	case pmtiles.Gzip:
+		return "gzip"
+	case pmtiles.Brotli:
+		return "brotli"
+	case pmtiles.Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("unknown (%d)", compression)
+	}
+}
+
+func noDecompressor(ctx context.Context, body []byte) ([]byte, error) {
+	return body, nil
+}
+
+func gzipDecompressor(ctx context.Context, body []byte) ([]byte, error) {
+
+	r, err := gzip.NewReader(bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
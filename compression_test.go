@@ -0,0 +1,76 @@
+package pmtiles
+
+import (
+	"context"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/protomaps/go-pmtiles/pmtiles"
+)
+
+func TestUnmarshalTile(t *testing.T) {
+
+	ctx := context.Background()
+
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Point{1, 1}))
+
+	layers := mvt.NewLayers(map[string]*geojson.FeatureCollection{
+		"whosonfirst": fc,
+	})
+
+	raw, err := mvt.Marshal(layers)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal layers, %v", err)
+	}
+
+	gzipped, err := mvt.MarshalGzipped(layers)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal gzipped layers, %v", err)
+	}
+
+	tests := []struct {
+		compression pmtiles.Compression
+		body        []byte
+	}{
+		{pmtiles.NoCompression, raw},
+		{pmtiles.Gzip, gzipped},
+		{pmtiles.UnknownCompression, raw},
+		{pmtiles.UnknownCompression, gzipped},
+	}
+
+	for _, test := range tests {
+
+		decoded, err := UnmarshalTile(ctx, test.compression, test.body)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %s tile, %v", compressionName(test.compression), err)
+		}
+
+		if len(decoded) != 1 || decoded[0].Name != "whosonfirst" || len(decoded[0].Features) != 1 {
+			t.Fatalf("Unexpected layers for %s tile", compressionName(test.compression))
+		}
+	}
+
+	_, err = UnmarshalTile(ctx, pmtiles.Gzip, raw)
+
+	if err == nil {
+		t.Fatalf("Expected error unmarshaling uncompressed tile as gzip")
+	}
+
+	_, err = UnmarshalTile(ctx, pmtiles.Zstd, raw)
+
+	if err == nil {
+		t.Fatalf("Expected error unmarshaling zstd tile without a registered decompressor")
+	}
+
+	err = RegisterTileDecompressor(ctx, pmtiles.Gzip, noDecompressor)
+
+	if err == nil {
+		t.Fatalf("Expected error registering gzip decompressor twice")
+	}
+}
@@ -61,3 +61,17 @@ func (c *Counter) Increment(key string, i int32) int32 {
 	c.lookup[key] = new_v
 	return new_v
 }
+
+// Prune removes the keys whose count is zero and for which 'f' returns true.
+func (c *Counter) Prune(f func(key string) bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, v := range c.lookup {
+
+		if v == 0 && f(key) {
+			delete(c.lookup, key)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package pmtiles
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/maptile/tilecover"
+)
+
+// TooManyTilesError is the error returned when the number of tiles needed to perform an intersects query exceeds
+// the limit defined by the ?max-intersects-tiles= parameter.
+type TooManyTilesError struct {
+	// The number of tiles needed to perform the query
+	Tiles int64
+	// The maximum number of tiles allowed
+	Max int64
+}
+
+func (e *TooManyTilesError) Error() string {
+	return fmt.Sprintf("Intersects query requires %d tiles which exceeds the maximum of %d", e.Tiles, e.Max)
+}
+
+// IntersectsTileCount returns the number of tiles which need to be read in order to perform an intersects query for
+// 'geom'. It is meant to be used to estimate the cost of an intersects query before performing it. For polygons the
+// count is derived without enumerating the tiles which are wholly contained by 'geom', and by only examining the
+// edges of 'geom' which touch each tile, so it is inexpensive even for very large geometries. See tilesForGeom for
+// details.
+func (db *PMTilesSpatialDatabase) IntersectsTileCount(ctx context.Context, geom orb.Geometry) (int64, error) {
+	return countTileCover(geom, maptile.Zoom(uint32(db.zoom)), maptile.Zoom(uint32(db.intersects_min_zoom)))
+}
+
+// tilesForGeom returns the tiles to read in order to find the features which intersect 'geom' and whether each of
+// them is wholly contained by 'geom'. Tiles which 'geom' only partially covers are at the zoom level used to read
+// features. Tiles wholly contained by 'geom' are the lowest ancestors of those tiles, no lower than the zoom level
+// defined by the ?intersects-min-zoom= parameter, which are also wholly contained by 'geom'. If the number of tiles
+// exceeds the ?max-intersects-tiles= limit then a `TooManyTilesError` error is returned before any tiles are read.
+func (db *PMTilesSpatialDatabase) tilesForGeom(ctx context.Context, geom orb.Geometry) (map[maptile.Tile]bool, error) {
+
+	zoom := maptile.Zoom(uint32(db.zoom))
+	min_zoom := maptile.Zoom(uint32(db.intersects_min_zoom))
+
+	if db.max_intersects_tiles > 0 {
+
+		count, err := countTileCover(geom, zoom, min_zoom)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if count > db.max_intersects_tiles {
+			return nil, &TooManyTilesError{Tiles: count, Max: db.max_intersects_tiles}
+		}
+	}
+
+	return tileCover(geom, zoom, min_zoom)
+}
+
+// countTileCover returns the number of tiles returned by tileCover for 'geom', 'zoom' and 'min_zoom'.
+func countTileCover(geom orb.Geometry, zoom maptile.Zoom, min_zoom maptile.Zoom) (int64, error) {
+
+	polys, ok := coverPolygons(geom)
+
+	if !ok {
+
+		tiles, err := tilecover.Geometry(geom, zoom)
+
+		if err != nil {
+			return 0, fmt.Errorf("Failed to derive tile cover, %w", err)
+		}
+
+		return int64(len(tiles)), nil
+	}
+
+	count := int64(0)
+
+	walkTileCover(polys, zoom, func(t maptile.Tile, within bool) {
+
+		if within && t.Z < min_zoom {
+			count += int64(1) << (2 * uint(min_zoom-t.Z))
+		} else {
+			count += 1
+		}
+	})
+
+	return count, nil
+}
+
+// tileCover returns the tiles which cover 'geom' and whether each of them is wholly contained by 'geom'. Tiles which
+// are not wholly contained by 'geom' are at 'zoom'. Tiles which are wholly contained by 'geom' are at the lowest zoom
+// level, no lower than 'min_zoom', at which they are still wholly contained.
+func tileCover(geom orb.Geometry, zoom maptile.Zoom, min_zoom maptile.Zoom) (map[maptile.Tile]bool, error) {
+
+	polys, ok := coverPolygons(geom)
+
+	if !ok {
+
+		tiles_set, err := tilecover.Geometry(geom, zoom)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive tile cover, %w", err)
+		}
+
+		tiles := make(map[maptile.Tile]bool, len(tiles_set))
+
+		for t := range tiles_set {
+			tiles[t] = false
+		}
+
+		return tiles, nil
+	}
+
+	tiles := make(map[maptile.Tile]bool)
+
+	walkTileCover(polys, zoom, func(t maptile.Tile, within bool) {
+
+		if !within {
+			tiles[t] = false
+			return
+		}
+
+		if t.Z >= min_zoom {
+			tiles[t] = true
+			return
+		}
+
+		min_x, max_x := t.X<<(min_zoom-t.Z), (t.X+1)<<(min_zoom-t.Z)
+		min_y, max_y := t.Y<<(min_zoom-t.Z), (t.Y+1)<<(min_zoom-t.Z)
+
+		for x := min_x; x < max_x; x++ {
+			for y := min_y; y < max_y; y++ {
+				tiles[maptile.New(x, y, min_zoom)] = true
+			}
+		}
+	})
+
+	return tiles, nil
+}
+
+// coverPolygons returns the polygons in 'geom' and a boolean value indicating whether 'geom' is a polygon,
+// multipolygon or bound.
+func coverPolygons(geom orb.Geometry) ([]orb.Polygon, bool) {
+
+	switch g := geom.(type) {
+	case orb.Polygon:
+		return []orb.Polygon{g}, true
+	case orb.MultiPolygon:
+		return g, true
+	case orb.Bound:
+		return []orb.Polygon{g.ToPolygon()}, true
+	default:
+		return nil, false
+	}
+}
+
+// coverEdge is a segment of one of the rings of a polygon.
+type coverEdge struct {
+	a orb.Point
+	b orb.Point
+}
+
+// walkTileCover invokes 'visit' for each of the tiles, at 'zoom', which the edges of 'polys' touch and for each of
+// the lowest tiles which are wholly contained by 'polys', indicated by the second argument, without descending in to
+// them. Tiles are subdivided starting from the tile at zoom 0 and only the edges which touch a tile are passed down
+// to its children. Whether the south-west corner of each tile is inside 'polys' is derived from that of its parent by
+// counting the edges crossed on the way from one corner to the other so no tile is compared against all the edges.
+func walkTileCover(polys []orb.Polygon, zoom maptile.Zoom, visit func(maptile.Tile, bool)) {
+
+	edges := make([]coverEdge, 0)
+
+	for _, poly := range polys {
+
+		for _, ring := range poly {
+
+			for i := 1; i < len(ring); i++ {
+				edges = append(edges, coverEdge{ring[i-1], ring[i]})
+			}
+
+			if len(ring) > 1 && !ring[0].Equal(ring[len(ring)-1]) {
+				edges = append(edges, coverEdge{ring[len(ring)-1], ring[0]})
+			}
+		}
+	}
+
+	root := maptile.New(0, 0, 0)
+	corner := root.Bound().Min
+
+	// Count the edges crossed by a ray from the corner to the east
+
+	inside := false
+
+	for _, e := range edges {
+
+		if e.crossesHorizontal(corner.Y(), corner.X(), math.Inf(1)) {
+			inside = !inside
+		}
+	}
+
+	var walk func(t maptile.Tile, edges []coverEdge, inside bool)
+
+	walk = func(t maptile.Tile, edges []coverEdge, inside bool) {
+
+		b := t.Bound()
+
+		local := make([]coverEdge, 0)
+
+		for _, e := range edges {
+
+			if segmentTouchesBound(e.a, e.b, b) {
+				local = append(local, e)
+			}
+		}
+
+		// A tile which no edges touch is either wholly inside or wholly outside the polygons
+
+		if len(local) == 0 {
+
+			if inside {
+				visit(t, true)
+			}
+
+			return
+		}
+
+		if t.Z == zoom {
+			visit(t, false)
+			return
+		}
+
+		for _, c := range t.Children() {
+
+			c_b := c.Bound()
+			c_inside := inside
+
+			// Walk east along the parent's southern edge then north to the child's corner
+
+			for _, e := range local {
+
+				if e.crossesHorizontal(b.Min.Y(), b.Min.X(), c_b.Min.X()) {
+					c_inside = !c_inside
+				}
+
+				if e.crossesVertical(c_b.Min.X(), b.Min.Y(), c_b.Min.Y()) {
+					c_inside = !c_inside
+				}
+			}
+
+			walk(c, local, c_inside)
+		}
+	}
+
+	walk(root, edges, inside)
+}
+
+// crossesHorizontal reports whether 'e' crosses the horizontal line at 'y' at a point greater than 'x0' and less than
+// or equal to 'x1'.
+func (e coverEdge) crossesHorizontal(y float64, x0 float64, x1 float64) bool {
+
+	if (e.a.Y() > y) == (e.b.Y() > y) {
+		return false
+	}
+
+	x := e.a.X() + (y-e.a.Y())*(e.b.X()-e.a.X())/(e.b.Y()-e.a.Y())
+	return x > x0 && x <= x1
+}
+
+// crossesVertical reports whether 'e' crosses the vertical line at 'x' at a point greater than 'y0' and less than
+// or equal to 'y1'.
+func (e coverEdge) crossesVertical(x float64, y0 float64, y1 float64) bool {
+
+	if (e.a.X() > x) == (e.b.X() > x) {
+		return false
+	}
+
+	y := e.a.Y() + (x-e.a.X())*(e.b.Y()-e.a.Y())/(e.b.X()-e.a.X())
+	return y > y0 && y <= y1
+}
+
+// segmentTouchesBound reports whether the segment 'a'-'c' touches 'b'.
+func segmentTouchesBound(a orb.Point, c orb.Point, b orb.Bound) bool {
+
+	if b.Contains(a) || b.Contains(c) {
+		return true
+	}
+
+	seg_bound := orb.Bound{Min: a, Max: a}.Extend(c)
+
+	if !seg_bound.Intersects(b) {
+		return false
+	}
+
+	corners := []orb.Point{
+		b.Min,
+		orb.Point{b.Max.X(), b.Min.Y()},
+		b.Max,
+		orb.Point{b.Min.X(), b.Max.Y()},
+	}
+
+	for j := 0; j < 4; j++ {
+
+		if segmentsIntersect(a, c, corners[j], corners[(j+1)%4]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// segmentsIntersect reports whether the segments 'p1'-'p2' and 'p3'-'p4' intersect.
+func segmentsIntersect(p1 orb.Point, p2 orb.Point, p3 orb.Point, p4 orb.Point) bool {
+
+	d1 := orientation(p3, p4, p1)
+	d2 := orientation(p3, p4, p2)
+	d3 := orientation(p1, p2, p3)
+	d4 := orientation(p1, p2, p4)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	switch {
+	case d1 == 0 && onSegment(p3, p4, p1):
+		return true
+	case d2 == 0 && onSegment(p3, p4, p2):
+		return true
+	case d3 == 0 && onSegment(p1, p2, p3):
+		return true
+	case d4 == 0 && onSegment(p1, p2, p4):
+		return true
+	}
+
+	return false
+}
+
+// orientation returns the cross product of the vectors 'a'-'b' and 'a'-'c'.
+func orientation(a orb.Point, b orb.Point, c orb.Point) float64 {
+	return (b.X()-a.X())*(c.Y()-a.Y()) - (b.Y()-a.Y())*(c.X()-a.X())
+}
+
+// onSegment reports whether 'p', which is assumed to be collinear with 'a'-'b', lies on that segment.
+func onSegment(a orb.Point, b orb.Point, p orb.Point) bool {
+	return min(a.X(), b.X()) <= p.X() && p.X() <= max(a.X(), b.X()) && min(a.Y(), b.Y()) <= p.Y() && p.Y() <= max(a.Y(), b.Y())
+}
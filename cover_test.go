@@ -0,0 +1,199 @@
+package pmtiles
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/planar"
+)
+
+func TestCountTileCover(t *testing.T) {
+
+	// A geometry slightly smaller than a single tile at zoom 10
+
+	t10 := maptile.New(163, 395, 10)
+	b := t10.Bound()
+
+	pad_x := (b.Max.X() - b.Min.X()) / 1000
+	pad_y := (b.Max.Y() - b.Min.Y()) / 1000
+
+	geom := orb.Bound{
+		Min: orb.Point{b.Min.X() + pad_x, b.Min.Y() + pad_y},
+		Max: orb.Point{b.Max.X() - pad_x, b.Max.Y() - pad_y},
+	}.ToPolygon()
+
+	count, err := countTileCover(geom, 12, 12)
+
+	if err != nil {
+		t.Fatalf("Failed to count tiles, %v", err)
+	}
+
+	if count != 16 {
+		t.Fatalf("Unexpected count (%d), expected 16", count)
+	}
+
+	// At zoom 14 only the tiles on the edges of the geometry (not the 14x14 interior
+	// tiles) need to be examined but the count should still be 16x16.
+
+	count, err = countTileCover(geom, 14, 14)
+
+	if err != nil {
+		t.Fatalf("Failed to count tiles, %v", err)
+	}
+
+	if count != 256 {
+		t.Fatalf("Unexpected count (%d), expected 256", count)
+	}
+
+	tiles, err := tileCover(geom, 14, 14)
+
+	if err != nil {
+		t.Fatalf("Failed to derive tile cover, %v", err)
+	}
+
+	// The 60 tiles on the edges of the geometry intersect it and the 14x14 interior
+	// tiles are wholly contained by it.
+
+	within := 0
+
+	for _, w := range tiles {
+
+		if w {
+			within += 1
+		}
+	}
+
+	if len(tiles) != 256 || within != 196 {
+		t.Fatalf("Unexpected tile cover (%d tiles, %d within), expected 256 tiles, 196 within", len(tiles), within)
+	}
+
+	// Tiles inside a hole are excluded
+
+	hole := orb.Bound{
+		Min: orb.Point{b.Min.X() + 0.3*(b.Max.X()-b.Min.X()), b.Min.Y() + 0.3*(b.Max.Y()-b.Min.Y())},
+		Max: orb.Point{b.Max.X() - 0.3*(b.Max.X()-b.Min.X()), b.Max.Y() - 0.3*(b.Max.Y()-b.Min.Y())},
+	}.ToPolygon()
+
+	donut := orb.Polygon{geom[0], hole[0]}
+
+	tiles, err = tileCover(donut, 14, 14)
+
+	if err != nil {
+		t.Fatalf("Failed to derive tile cover, %v", err)
+	}
+
+	count, err = countTileCover(donut, 14, 14)
+
+	if err != nil {
+		t.Fatalf("Failed to count tiles, %v", err)
+	}
+
+	if count != int64(len(tiles)) {
+		t.Fatalf("Unexpected count (%d), expected %d", count, len(tiles))
+	}
+
+	for tile, w := range tiles {
+
+		center := tile.Bound().Center()
+
+		if hole.Bound().Contains(tile.Bound().Min) && hole.Bound().Contains(tile.Bound().Max) {
+			t.Fatalf("Expected tile %d/%d/%d inside hole to be excluded", tile.Z, tile.X, tile.Y)
+		}
+
+		if w && !planar.PolygonContains(donut, center) {
+			t.Fatalf("Expected tile %d/%d/%d to be wholly contained by geometry", tile.Z, tile.X, tile.Y)
+		}
+	}
+}
+
+func TestHierarchicalTileCover(t *testing.T) {
+
+	// A geometry slightly smaller than a single tile at zoom 10 which wholly contains the
+	// four interior tiles at zoom 12
+
+	t10 := maptile.New(163, 395, 10)
+	b := t10.Bound()
+
+	pad_x := (b.Max.X() - b.Min.X()) / 1000
+	pad_y := (b.Max.Y() - b.Min.Y()) / 1000
+
+	geom := orb.Bound{
+		Min: orb.Point{b.Min.X() + pad_x, b.Min.Y() + pad_y},
+		Max: orb.Point{b.Max.X() - pad_x, b.Max.Y() - pad_y},
+	}.ToPolygon()
+
+	leaves, err := tileCover(geom, 14, 14)
+
+	if err != nil {
+		t.Fatalf("Failed to derive tile cover, %v", err)
+	}
+
+	tiles, err := tileCover(geom, 14, 12)
+
+	if err != nil {
+		t.Fatalf("Failed to derive tile cover, %v", err)
+	}
+
+	count, err := countTileCover(geom, 14, 12)
+
+	if err != nil {
+		t.Fatalf("Failed to count tiles, %v", err)
+	}
+
+	if count != int64(len(tiles)) {
+		t.Fatalf("Unexpected count (%d), expected %d", count, len(tiles))
+	}
+
+	if len(tiles) >= len(leaves) {
+		t.Fatalf("Expected fewer tiles (%d) than the tiles at zoom 14 (%d)", len(tiles), len(leaves))
+	}
+
+	for _, x := range []uint32{653, 654} {
+
+		for _, y := range []uint32{1581, 1582} {
+
+			if !tiles[maptile.New(x, y, 12)] {
+				t.Fatalf("Expected tile 12/%d/%d to be wholly contained by geometry", x, y)
+			}
+		}
+	}
+
+	// The tiles cover the same area, with the same tiles wholly contained by the geometry, as
+	// the tiles at zoom 14
+
+	expanded := make(map[maptile.Tile]bool)
+
+	for tile, w := range tiles {
+
+		if tile.Z < 12 {
+			t.Fatalf("Unexpected tile %d/%d/%d below minimum zoom", tile.Z, tile.X, tile.Y)
+		}
+
+		if !w && tile.Z != 14 {
+			t.Fatalf("Expected tile %d/%d/%d which is not wholly contained by geometry to be at zoom 14", tile.Z, tile.X, tile.Y)
+		}
+
+		min_x, max_x := tile.X<<(14-tile.Z), (tile.X+1)<<(14-tile.Z)
+		min_y, max_y := tile.Y<<(14-tile.Z), (tile.Y+1)<<(14-tile.Z)
+
+		for x := min_x; x < max_x; x++ {
+			for y := min_y; y < max_y; y++ {
+				expanded[maptile.New(x, y, 14)] = w
+			}
+		}
+	}
+
+	if len(expanded) != len(leaves) {
+		t.Fatalf("Unexpected number of tiles at zoom 14 (%d), expected %d", len(expanded), len(leaves))
+	}
+
+	for tile, w := range leaves {
+
+		e_w, exists := expanded[tile]
+
+		if !exists || e_w != w {
+			t.Fatalf("Unexpected tile %d/%d/%d (exists: %t, within: %t), expected within: %t", tile.Z, tile.X, tile.Y, exists, e_w, w)
+		}
+	}
+}
@@ -2,11 +2,19 @@ package pmtiles
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/aaronland/gocloud-blob/s3"
@@ -23,6 +31,10 @@ import (
 	"github.com/whosonfirst/go-whosonfirst-spr/v2"
 )
 
+// spatial_database_namespaces is the number of PMTilesSpatialDatabase instances created by the current process.
+// It is used to derive a unique namespace for the (per-tile) spatial databases created by each instance.
+var spatial_database_namespaces int64
+
 func init() {
 	ctx := context.Background()
 	database.RegisterSpatialDatabase(ctx, "pmtiles", NewPMTilesSpatialDatabase)
@@ -41,22 +53,49 @@ func (r *PMTilesResults) Results() []spr.StandardPlacesResult {
 type PMTilesSpatialDatabase struct {
 	database.SpatialDatabase
 	server                           *pmtiles.Server
+	bucket                           pmtiles.Bucket
+	tile_cache                       *TileCache
 	database                         string
-	layer                            string
+	layers                           []string
 	enable_feature_cache             bool
+	decode_json_properties           bool
 	cache_manager                    cache.CacheManager
+	assembly_locks                   assemblyLocks
 	zoom                             int
+	overzoom_missing_tiles           bool
+	tile_fetch_concurrency           int
+	tile_fetches                     atomic.Int64
+	tile_fetches_peak                atomic.Int64
+	tile_fetches_total               atomic.Int64
+	max_intersects_tiles             int64
+	intersects_min_zoom              int
+	manifest                         string
+	router                           atomic.Pointer[tileRouter]
+	router_mutex                     *sync.Mutex
 	spatial_database_uri             string
+	spatial_database_namespace       string
 	spatial_databases_ttl            int
 	spatial_databases_counter        *Counter
 	spatial_databases_releaser       map[string]time.Time
 	spatial_databases_cache          map[string]database.SpatialDatabase
 	spatial_databases_cache_mutex    *sync.RWMutex
 	spatial_databases_releaser_mutex *sync.RWMutex
+	spatial_databases_inflight       map[string]*tileDatabaseBuild
+	spatial_databases_inflight_mutex *sync.Mutex
+	spatial_databases_lru            *LRU
+	max_spatial_databases            int
+	max_spatial_databases_memory     int64
 
 	spatial_databases_ticker      *time.Ticker
 	spatial_databases_ticker_done chan bool
 
+	reload_ticker  *time.Ticker
+	reload_signals chan os.Signal
+	reload_cancel  context.CancelFunc
+	reload_wg      *sync.WaitGroup
+
+	feature_cache_admin *http.Server
+
 	count_pip int64
 }
 
@@ -76,14 +115,81 @@ func NewPMTilesSpatialDatabase(ctx context.Context, uri string) (database.Spatia
 
 	q_tile_path := q.Get("tiles")
 	q_database := q.Get("database")
-	q_layer := q.Get("layer")
 
-	if q_layer == "" {
-		q_layer = q_database
+	// Features may be read from multiple layers, specified either as a comma-separated
+	// list or by repeating the ?layer= parameter. The order in which layers are listed
+	// defines their precedence when the same feature appears in more than one layer.
+
+	q_layers := make([]string, 0)
+
+	for _, v := range q["layer"] {
+
+		for _, l := range strings.Split(v, ",") {
+
+			l = strings.TrimSpace(l)
+
+			if l == "" {
+				continue
+			}
+
+			if slices.Contains(q_layers, l) {
+				return nil, fmt.Errorf("Invalid ?layer= parameter, %s is listed more than once", l)
+			}
+
+			q_layers = append(q_layers, l)
+		}
+	}
+
+	// Tiles may be read from regional databases listed in a manifest (see manifest.go) in
+	// which case the ?database= parameter is optional and, if present, is used for tiles
+	// which are not covered by the manifest.
+
+	q_manifest := q.Get("manifest")
+
+	if q_database == "" && q_manifest == "" {
+		return nil, fmt.Errorf("Missing ?database= parameter")
+	}
+
+	// Optionally check whether the manifest, or any of the databases, have been replaced
+	// periodically and/or when the current process receives a SIGHUP signal. See reload.go
+	// for details.
+
+	reload_interval := 0 // seconds
+
+	if q.Has("reload-interval") {
+
+		v, err := strconv.Atoi(q.Get("reload-interval"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?reload-interval= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?reload-interval= parameter, must be greater than or equal to zero")
+		}
+
+		reload_interval = v
+	}
+
+	reload_on_sighup := false
+
+	if q.Has("reload-on-sighup") {
+
+		v, err := strconv.ParseBool(q.Get("reload-on-sighup"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?reload-on-sighup= parameter, %w", err)
+		}
+
+		reload_on_sighup = v
 	}
 
 	cache_size := 64
-	zoom := 12
+
+	// If zoom is not defined it is derived from the maximum zoom level of the PMTiles
+	// database (see below)
+
+	zoom := -1
 
 	q_cache_size := q.Get("pmtiles-cache-size")
 
@@ -108,19 +214,166 @@ func NewPMTilesSpatialDatabase(ctx context.Context, uri string) (database.Spatia
 			return nil, fmt.Errorf("Failed to parse ?zoom= parameter, %w", err)
 		}
 
+		if z < 0 {
+			return nil, fmt.Errorf("Invalid ?zoom= parameter, must be a positive integer")
+		}
+
 		zoom = z
 	}
 
+	// The maximum number of tiles to read at the same time when performing
+	// intersects queries.
+
+	tile_fetch_concurrency := 16
+
+	if q.Has("tile-fetch-concurrency") {
+
+		v, err := strconv.Atoi(q.Get("tile-fetch-concurrency"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?tile-fetch-concurrency= parameter, %w", err)
+		}
+
+		if v < 1 {
+			return nil, fmt.Errorf("Invalid ?tile-fetch-concurrency= parameter, must be greater than zero")
+		}
+
+		tile_fetch_concurrency = v
+	}
+
+	// The maximum number of tiles to read when performing intersects queries. A value
+	// of 0 means there is no limit. See tilesForGeom for details.
+
+	max_intersects_tiles := int64(0)
+
+	if q.Has("max-intersects-tiles") {
+
+		v, err := strconv.ParseInt(q.Get("max-intersects-tiles"), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max-intersects-tiles= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?max-intersects-tiles= parameter, must be greater than or equal to zero")
+		}
+
+		max_intersects_tiles = v
+	}
+
+	// The lowest zoom level at which tiles wholly contained by the geometry of an intersects
+	// query may be read instead of all their descendants at the zoom level defined above. If
+	// not defined it is the same as that zoom level. See tilesForGeom for details.
+
+	intersects_min_zoom := -1
+
+	if q.Has("intersects-min-zoom") {
+
+		v, err := strconv.Atoi(q.Get("intersects-min-zoom"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?intersects-min-zoom= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?intersects-min-zoom= parameter, must be a positive integer")
+		}
+
+		intersects_min_zoom = v
+	}
+
 	logger := slog.Default()
 	log_logger := slog.NewLogLogger(logger.Handler(), slog.LevelDebug)
 
-	server, err := pmtiles.NewServer(q_tile_path, "", log_logger, cache_size, "")
+	// Open the bucket containing the PMTiles database explicitly, rather than letting
+	// pmtiles.NewServer do it, so that it can be used to read the database header.
+
+	bucket_uri, _, err := pmtiles.NormalizeBucketKey(q_tile_path, "", "")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to normalize ?tiles= parameter, %w", err)
+	}
+
+	bucket, err := pmtiles.OpenBucket(ctx, bucket_uri, "")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open bucket for ?tiles= parameter, %w", err)
+	}
+
+	server, err := pmtiles.NewServerWithBucket(bucket, "", log_logger, cache_size, "")
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create pmtiles.Loop, %w", err)
 	}
 
-	server.Start()
+	// Read the database header(s) and metadata now so that configuration errors (a missing
+	// database or layer, an invalid zoom level) surface immediately rather than as empty
+	// results or errors when the first query is performed. They are read from the bucket
+	// directly since the server, like everything else which runs in the background, is
+	// not started until all the remaining setup has succeeded (see below).
+
+	var manifest *Manifest
+	var manifest_id string
+
+	if q_manifest != "" {
+
+		manifest, manifest_id, err = readManifest(ctx, bucket, q_manifest)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(q_layers) == 0 {
+
+		name := q_database
+
+		if name == "" {
+			name = manifest.Databases()[0]
+		}
+
+		metadata, err := readArchiveMetadata(ctx, bucket, name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		layer, err := metadata.defaultLayer(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		q_layers = append(q_layers, layer)
+	}
+
+	var primary *tileArchive
+
+	if q_database != "" {
+
+		primary, err = loadTileArchive(ctx, bucket, q_database, q_layers)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Optionally, when a tile is missing from the database (for example because it was
+	// dropped when the database was created) read features from its nearest ancestor
+	// tile instead.
+
+	overzoom_missing_tiles := false
+
+	if q.Has("overzoom-missing-tiles") {
+
+		v, err := strconv.ParseBool(q.Get("overzoom-missing-tiles"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?overzoom-missing-tiles= parameter, %w", err)
+		}
+
+		overzoom_missing_tiles = v
+	}
 
 	spatial_databases_ttl := 30 // seconds
 
@@ -135,7 +388,54 @@ func NewPMTilesSpatialDatabase(ctx context.Context, uri string) (database.Spatia
 		spatial_databases_ttl = v
 	}
 
+	// A value of 0 disables pruning spatial databases after they have not been used
+	// for a period of time in which case they are only removed when one of the limits
+	// below is exceeded.
+
+	if spatial_databases_ttl < 0 {
+		return nil, fmt.Errorf("Invalid ?database-ttl= parameter, must be greater than or equal to zero")
+	}
+
+	// The maximum number of spatial databases, and their estimated combined size in
+	// megabytes, to keep in memory at any given time. When either limit is exceeded
+	// the least recently used databases which are not currently being queried are
+	// removed. A value of 0 means there is no limit.
+
+	max_spatial_databases := 0
+	max_spatial_databases_memory := int64(0)
+
+	if q.Has("max-tile-databases") {
+
+		v, err := strconv.Atoi(q.Get("max-tile-databases"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max-tile-databases= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?max-tile-databases= parameter, must be greater than or equal to zero")
+		}
+
+		max_spatial_databases = v
+	}
+
+	if q.Has("max-tile-memory") {
+
+		v, err := strconv.ParseInt(q.Get("max-tile-memory"), 10, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?max-tile-memory= parameter, %w", err)
+		}
+
+		if v < 0 {
+			return nil, fmt.Errorf("Invalid ?max-tile-memory= parameter, must be greater than or equal to zero")
+		}
+
+		max_spatial_databases_memory = v * 1024 * 1024
+	}
+
 	spatial_databases_counter := NewCounter()
+	spatial_databases_lru := NewLRU()
 
 	spatial_databases_releaser := make(map[string]time.Time)
 	spatial_databases_releaser_mutex := new(sync.RWMutex)
@@ -143,50 +443,186 @@ func NewPMTilesSpatialDatabase(ctx context.Context, uri string) (database.Spatia
 	spatial_databases_cache := make(map[string]database.SpatialDatabase)
 	spatial_databases_cache_mutex := new(sync.RWMutex)
 
-	spatial_databases_ticker := time.NewTicker(time.Duration(spatial_databases_ttl) * time.Second)
-	spatial_databases_ticker_done := make(chan bool)
+	spatial_databases_inflight := make(map[string]*tileDatabaseBuild)
+	spatial_databases_inflight_mutex := new(sync.Mutex)
 
-	// To do: Check for query value
+	// By default the features in individual tiles are indexed using an in-memory
+	// TileSpatialDatabase instance (registered as native://) which operates directly on
+	// the geometries decoded from MVT data. Prior to its introduction the default was an
+	// in-memory SQLite database. Any other registered database.SpatialDatabase
+	// implementation can be used instead. For example ?tile-database-uri=rtree:// or a
+	// SQLite database:
+	//
+	// sqlite://sqlite?dsn=file:{dbname}?mode=memory&cache=shared
+	//
+	// Note the {dbname}. This gets swapped out in spatialDatabaseFromTile. That's
+	// important because it allows the creation of discrete databases in memory which
+	// can be disconnected/deleted in order to free up memory.
 
-	// This triggers "distance errors" which I don't really understand yet
-	// spatial_database_uri := "rtree://"
+	spatial_database_uri := fmt.Sprintf("%s://", NATIVE_TILE_DATABASE_SCHEME)
 
-	// Note the {dbname}. This gets swapped out in spatialDatabaseFromTile.
-	// That's important because it allows the creation of discrete databases
-	// in memory which can be disconnected/deleted in order to free up memory.
-	dsn := url.QueryEscape("file:{dbname}?mode=memory&cache=shared")
+	if q.Has("tile-database-uri") {
 
-	spatial_database_uri := fmt.Sprintf("sqlite://sqlite?dsn=%s", dsn)
+		v := q.Get("tile-database-uri")
+
+		tile_u, err := url.Parse(v)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?tile-database-uri= parameter, %w", err)
+		}
+
+		if tile_u.Scheme == u.Scheme {
+			return nil, fmt.Errorf("Invalid ?tile-database-uri= parameter, %s:// databases can not be used to index tiles", u.Scheme)
+		}
+
+		if !slices.Contains(database.Schemes(), fmt.Sprintf("%s://", tile_u.Scheme)) {
+			return nil, fmt.Errorf("Invalid ?tile-database-uri= parameter, %s:// is not a registered spatial database scheme", tile_u.Scheme)
+		}
+
+		spatial_database_uri = v
+	}
+
+	// Each instance gets its own namespace which is prepended to the name of every
+	// per-tile spatial database it creates. Without it two instances in the same process
+	// (reading different databases or layers) using shared in-memory SQLite databases,
+	// for example, would end up reading and writing the same per-tile databases.
+
+	spatial_database_namespace := fmt.Sprintf("pmtiles%d_%d", os.Getpid(), atomic.AddInt64(&spatial_database_namespaces, 1))
 
 	db := &PMTilesSpatialDatabase{
 		server:                           server,
+		bucket:                           bucket,
 		database:                         q_database,
-		layer:                            q_layer,
+		layers:                           q_layers,
 		zoom:                             zoom,
+		overzoom_missing_tiles:           overzoom_missing_tiles,
+		tile_fetch_concurrency:           tile_fetch_concurrency,
+		max_intersects_tiles:             max_intersects_tiles,
+		intersects_min_zoom:              intersects_min_zoom,
+		manifest:                         q_manifest,
+		router_mutex:                     new(sync.Mutex),
+		assembly_locks:                   newAssemblyLocks(64),
 		spatial_database_uri:             spatial_database_uri,
+		spatial_database_namespace:       spatial_database_namespace,
 		spatial_databases_ttl:            spatial_databases_ttl,
 		spatial_databases_counter:        spatial_databases_counter,
 		spatial_databases_releaser:       spatial_databases_releaser,
 		spatial_databases_releaser_mutex: spatial_databases_releaser_mutex,
 		spatial_databases_cache:          spatial_databases_cache,
 		spatial_databases_cache_mutex:    spatial_databases_cache_mutex,
-		spatial_databases_ticker:         spatial_databases_ticker,
-		spatial_databases_ticker_done:    spatial_databases_ticker_done,
+		spatial_databases_inflight:       spatial_databases_inflight,
+		spatial_databases_inflight_mutex: spatial_databases_inflight_mutex,
+		spatial_databases_lru:            spatial_databases_lru,
+		max_spatial_databases:            max_spatial_databases,
+		max_spatial_databases_memory:     max_spatial_databases_memory,
 		count_pip:                        int64(0),
 	}
 
-	go func() {
+	loaded := make(map[string]*tileArchive)
+
+	if primary != nil {
+		loaded[primary.name] = primary
+	}
+
+	router, err := db.newTileRouter(ctx, primary, manifest, manifest_id, loaded, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// If zoom is greater than the maximum zoom level at which tiles are stored then
+	// features are read from the ancestor tile at that zoom level (overzooming) and
+	// clipped to the tile being queried. See featuresForTile for details.
+
+	if db.zoom == -1 {
+		db.zoom = router.maxZoom()
+	}
+
+	err = router.validateZoom("zoom", db.zoom, db.layers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if db.intersects_min_zoom == -1 {
+		db.intersects_min_zoom = db.zoom
+	}
+
+	if db.intersects_min_zoom > db.zoom {
+		return nil, fmt.Errorf("Invalid ?intersects-min-zoom= parameter, must be less than or equal to %d", db.zoom)
+	}
+
+	if db.intersects_min_zoom != db.zoom {
+
+		err = router.validateZoom("intersects-min-zoom", db.intersects_min_zoom, db.layers)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.router.Store(router)
+
+	if q.Has("decode-json-properties") {
+
+		v, err := strconv.ParseBool(q.Get("decode-json-properties"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse ?decode-json-properties= parameter, %w", err)
+		}
+
+		db.decode_json_properties = v
+	}
+
+	// Optionally store the per-tile spatial databases on disk so that they can be reused,
+	// including after a restart, instead of fetching, decoding and indexing the features in
+	// each tile again. Databases are stored in a directory specific to each PMTiles database
+	// (derived from its header and etag), and the options used to build them, so that
+	// replacing a database, or changing those options, does not cause stale databases to
+	// be used. Only native:// databases, which are serialized, and sqlite:// databases,
+	// which are written to disk, can be stored.
+
+	if q.Has("tile-cache-dir") {
+
+		tile_cache_dir := q.Get("tile-cache-dir")
+
+		if tile_cache_dir == "" {
+			return nil, fmt.Errorf("Invalid ?tile-cache-dir= parameter, must not be empty")
+		}
 
-		for {
-			select {
-			case <-db.spatial_databases_ticker_done:
-				return
-			case <-spatial_databases_ticker.C:
-				db.pruneSpatialDatabases(ctx)
+		err := validateTileCacheDatabaseURI(spatial_database_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?tile-cache-dir= parameter, %w", err)
+		}
+
+		tile_cache_size := int64(256)
+
+		if q.Has("tile-cache-max-size") {
+
+			v, err := strconv.ParseInt(q.Get("tile-cache-max-size"), 10, 64)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse ?tile-cache-max-size= parameter, %w", err)
 			}
+
+			if v < 0 {
+				return nil, fmt.Errorf("Invalid ?tile-cache-max-size= parameter, must be greater than or equal to zero")
+			}
+
+			tile_cache_size = v
 		}
 
-	}()
+		archive_id := router.archives()[0].id
+
+		tile_cache, err := NewTileCache(ctx, tile_cache_dir, archive_id, db.tileCacheVariant(), tile_cache_size*1024*1024)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create tile cache, %w", err)
+		}
+
+		db.tile_cache = tile_cache
+	}
 
 	//
 
@@ -208,12 +644,40 @@ func NewPMTilesSpatialDatabase(ctx context.Context, uri string) (database.Spatia
 
 	if enable_feature_cache {
 
-		// To do: Read from DB URI. Given that we weren't doing this for the original
-		// docstore/mem stuff it seems okay just to swap out defaults. Note that we
-		// are using https://pkg.go.dev/modernc.org/sqlite which is assumed to have
-		// already been loaded (by go-whosonnfirst-spatial-sqlite)
+		// Any registered cache.CacheManager implementation can be used to cache features. The
+		// default is a temporary SQLite database. Note that we are using
+		// https://pkg.go.dev/modernc.org/sqlite which is assumed to have already been loaded
+		// (by go-whosonnfirst-spatial-sqlite)
 
 		cache_manager_uri := "sql://sqlite?dsn={tmp}"
+
+		if q.Has("feature-cache-uri") {
+			cache_manager_uri = q.Get("feature-cache-uri")
+		}
+
+		cache_ttl := 300 // seconds
+
+		if q.Has("cache-ttl") {
+
+			v, err := strconv.Atoi(q.Get("cache-ttl"))
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse ?cache-ttl= parameter, %w", err)
+			}
+
+			if v < 1 {
+				return nil, fmt.Errorf("Invalid ?cache-ttl= parameter, must be greater than zero")
+			}
+
+			cache_ttl = v
+		}
+
+		cache_manager_uri, err := featureCacheURI(cache_manager_uri, cache_ttl)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid ?feature-cache-uri= parameter, %w", err)
+		}
+
 		cache_manager, err := cache.NewCacheManager(ctx, cache_manager_uri)
 
 		if err != nil {
@@ -224,5 +688,178 @@ func NewPMTilesSpatialDatabase(ctx context.Context, uri string) (database.Spatia
 		db.enable_feature_cache = enable_feature_cache
 	}
 
+	// Optionally expose the feature cache over HTTP (see FeatureCacheHandler), on an address separate
+	// from the application using the database, so that it can be inspected and purged while in use.
+
+	var feature_cache_admin_listener net.Listener
+
+	if q.Has("feature-cache-admin-address") {
+
+		if !db.enable_feature_cache {
+			return nil, fmt.Errorf("Invalid ?feature-cache-admin-address= parameter, requires ?enable-cache=true")
+		}
+
+		handler, err := FeatureCacheHandler(db)
+
+		if err != nil {
+			db.cache_manager.Close()
+			return nil, fmt.Errorf("Failed to create feature cache handler, %w", err)
+		}
+
+		listener, err := net.Listen("tcp", q.Get("feature-cache-admin-address"))
+
+		if err != nil {
+			db.cache_manager.Close()
+			return nil, fmt.Errorf("Failed to listen on ?feature-cache-admin-address= parameter, %w", err)
+		}
+
+		feature_cache_admin_listener = listener
+
+		db.feature_cache_admin = &http.Server{
+			Handler: handler,
+		}
+	}
+
+	// Everything which runs in the background is started last so that nothing is left
+	// running if any of the setup above fails. They are stopped by the Disconnect method,
+	// with the exception of the server which can not be stopped.
+
+	server.Start()
+
+	if spatial_databases_ttl > 0 {
+
+		spatial_databases_ticker := time.NewTicker(time.Duration(spatial_databases_ttl) * time.Second)
+		spatial_databases_ticker_done := make(chan bool)
+
+		db.spatial_databases_ticker = spatial_databases_ticker
+		db.spatial_databases_ticker_done = spatial_databases_ticker_done
+
+		go func() {
+
+			for {
+				select {
+				case <-db.spatial_databases_ticker_done:
+					return
+				case <-spatial_databases_ticker.C:
+					db.pruneSpatialDatabases(ctx)
+				}
+			}
+
+		}()
+	}
+
+	if reload_interval > 0 || reload_on_sighup {
+		db.reloadInBackground(ctx, time.Duration(reload_interval)*time.Second, reload_on_sighup)
+	}
+
+	if db.feature_cache_admin != nil {
+
+		go func() {
+
+			err := db.feature_cache_admin.Serve(feature_cache_admin_listener)
+
+			if err != nil && err != http.ErrServerClosed {
+				slog.Error("Failed to serve feature cache handler", "error", err)
+			}
+		}()
+	}
+
 	return db, nil
 }
+
+// FeatureCache returns the `cache.CacheManager` instance used to cache features and a boolean value indicating
+// whether the feature cache is enabled. It is meant to allow applications to inspect, or purge, the feature cache
+// while the database is in use.
+func (db *PMTilesSpatialDatabase) FeatureCache() (cache.CacheManager, bool) {
+
+	if !db.enable_feature_cache || db.cache_manager == nil {
+		return nil, false
+	}
+
+	return db.cache_manager, true
+}
+
+// featureCacheURI returns the URI used to create the `cache.CacheManager` instance for the feature cache derived
+// from 'uri'. Any occurrences of the string "{key}" in the path of 'uri' are replaced with the name of the field
+// used to key cached features (for docstore collection URIs like "mem://pmtiles_features/{key}"). If 'uri' does not
+// have a "ttl" query parameter then it is assigned 'ttl'. Cache managers which do not support expiring cached
+// features ignore it.
+func featureCacheURI(uri string, ttl int) (string, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	if u.Scheme == "" {
+		return "", fmt.Errorf("Missing scheme")
+	}
+
+	if !slices.Contains(cache.CacheManagerSchemes(), fmt.Sprintf("%s://", u.Scheme)) {
+		return "", fmt.Errorf("%s:// is not a registered cache manager scheme", u.Scheme)
+	}
+
+	u.Path = strings.Replace(u.Path, "{key}", "Id", -1)
+
+	q := u.Query()
+
+	if !q.Has("ttl") {
+		q.Set("ttl", strconv.Itoa(ttl))
+	}
+
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// validateTileCacheDatabaseURI returns an error if the per-tile spatial databases created using 'uri' can not be
+// stored in the tile cache. Only native:// databases and sqlite:// databases whose DSN contains the string "{dbname}",
+// which is replaced with the path of the database in the tile cache, and is not stored in memory can be stored.
+func validateTileCacheDatabaseURI(uri string) error {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse tile database URI, %w", err)
+	}
+
+	switch u.Scheme {
+	case NATIVE_TILE_DATABASE_SCHEME:
+		return nil
+	case "sqlite":
+
+		dsn := u.Query().Get("dsn")
+
+		if !strings.Contains(dsn, "{dbname}") {
+			return fmt.Errorf("sqlite:// tile database DSN must contain {dbname}")
+		}
+
+		if strings.Contains(dsn, "mode=memory") {
+			return fmt.Errorf("sqlite:// tile database DSN must not be stored in memory")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%s:// tile databases can not be stored in the tile cache", u.Scheme)
+	}
+}
+
+// tileCacheVariant returns a value derived from the options used to build the per-tile spatial databases, which
+// determine the features they contain and how they are encoded, so that databases built using different options
+// are never read from the tile cache.
+func (db *PMTilesSpatialDatabase) tileCacheVariant() string {
+
+	scheme := db.spatial_database_uri
+
+	u, err := url.Parse(db.spatial_database_uri)
+
+	if err == nil {
+		scheme = u.Scheme
+	}
+
+	opts := fmt.Sprintf("%s#%d#%s#%t#%t", scheme, tile_database_encoding_version, strings.Join(db.layers, ","), db.overzoom_missing_tiles, db.decode_json_properties)
+
+	sum := sha256.Sum256([]byte(opts))
+	return hex.EncodeToString(sum[:8])
+}
@@ -3,23 +3,25 @@ package pmtiles
 // Implement the whosonfirst/go-whosonfirst-spatial.SpatialIndex interface.
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"iter"
 	"log/slog"
 	"math/rand/v2"
 	"net/url"
+	"os"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
 	"github.com/paulmach/orb/encoding/mvt"
 	"github.com/paulmach/orb/geojson"
 	"github.com/paulmach/orb/maptile"
-	"github.com/paulmach/orb/maptile/tilecover"
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/sjson"
 	"github.com/whosonfirst/go-whosonfirst-spatial"
@@ -38,14 +40,17 @@ func (db *PMTilesSpatialDatabase) RemoveFeature(context.Context, string) error {
 
 func (db *PMTilesSpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
 
-	spatial_db, err := db.spatialDatabaseFromCoord(ctx, coord)
+	router := db.acquireTileRouter()
+	defer db.releaseTileRouter(router)
+
+	spatial_db, db_name, err := db.spatialDatabaseFromCoord(ctx, router, coord)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create spatial database, %w", err)
 	}
 
 	defer func() {
-		db.releaseSpatialDatabase(ctx, coord)
+		db.releaseSpatialDatabase(ctx, db_name)
 		go atomic.AddInt64(&db.count_pip, 1)
 	}()
 
@@ -56,7 +61,10 @@ func (db *PMTilesSpatialDatabase) PointInPolygonWithIterator(ctx context.Context
 
 	return func(yield func(spr.StandardPlacesResult, error) bool) {
 
-		spatial_db, err := db.spatialDatabaseFromCoord(ctx, coord)
+		router := db.acquireTileRouter()
+		defer db.releaseTileRouter(router)
+
+		spatial_db, db_name, err := db.spatialDatabaseFromCoord(ctx, router, coord)
 
 		if err != nil {
 			yield(nil, fmt.Errorf("Failed to create spatial database, %w", err))
@@ -64,7 +72,7 @@ func (db *PMTilesSpatialDatabase) PointInPolygonWithIterator(ctx context.Context
 		}
 
 		defer func() {
-			db.releaseSpatialDatabase(ctx, coord)
+			db.releaseSpatialDatabase(ctx, db_name)
 			go atomic.AddInt64(&db.count_pip, 1)
 		}()
 
@@ -102,72 +110,110 @@ func (db *PMTilesSpatialDatabase) IntersectsWithIterator(ctx context.Context, ge
 
 	return func(yield func(spr.StandardPlacesResult, error) bool) {
 
-		features, err := db.featuresFromTilesForGeom(ctx, geom)
+		// Results are yielded as soon as the features for each tile covering geom have
+		// been read rather than waiting for all the tiles to be read. Features which span
+		// multiple tiles are only yielded once, the first time one of their (clipped)
+		// geometries is found to intersect geom. Note that this means the SPR for a
+		// feature reflects the geometry in the tile where it was first found.
 
-		if err != nil {
-			yield(nil, err)
-			return
-		}
+		// The IDs of features which have been yielded (true) or which intersect geom but
+		// do not match filters (false).
+		matches := make(map[int64]bool)
 
-		wg := new(sync.WaitGroup)
+		// The (clipped) features, and the tiles they were read from, for each ID in matches
+		// used to assemble the features that are cached once all the tiles have been read
+		// (or iteration has stopped).
+		pieces := make(map[int64][]*featurePiece)
+
+		// All the tiles for a query are read using the same router even if the databases
+		// are reloaded in the meantime (see reload.go).
 
-		for id, id_features := range features {
+		router := db.acquireTileRouter()
 
-			logger := slog.Default()
-			logger = logger.With("id", id)
+		defer func() {
+
+			if db.enable_feature_cache {
+				db.cacheFeaturePieces(ctx, router.epoch, pieces)
+			}
 
-			intersects := false
+			db.releaseTileRouter(router)
+		}()
 
-			for _, f := range id_features {
+		for tf, err := range db.featuresFromTilesForGeom(ctx, router, geom) {
 
-				f_geom := f.Geometry
+			if err != nil {
+				yield(nil, err)
+				return
+			}
 
-				f_intersects, err := geo.Intersects(f_geom, geom)
+			// Tiles wholly contained by geom may have been read at a lower zoom level (see
+			// tilesForGeom) where geometries are simplified so they are not used to assemble
+			// the features stored in the feature cache.
 
-				if err != nil {
-					logger.Error("Failed to determine if feature intersects", "error", err)
+			cache_pieces := db.enable_feature_cache && int(tf.tile.Z) == db.zoom
+			tile_bound := tf.tile.Bound()
+
+			// Skip IDs we've seen more than once in this tile
+
+			seen := make(map[int64]bool)
+
+			for _, f := range tf.features {
+
+				id, ok := featureId(f)
+
+				if !ok {
+					slog.Warn("Unexpected WOF ID", "raw", f.ID)
 					continue
 				}
 
-				intersects = f_intersects
+				_, exists := seen[id]
 
-				if intersects {
-					logger.Debug("Feature does not intersect")
-					break
+				if exists {
+					continue
 				}
-			}
 
-			if intersects {
+				seen[id] = true
+
+				logger := slog.Default()
+				logger = logger.With("id", id)
 
-				var f *geojson.Feature
+				_, matched := matches[id]
 
-				switch len(id_features) {
-				case 1:
-					f = id_features[0]
-				default:
+				if matched {
 
-					f = id_features[0]
+					if cache_pieces {
 
-					// Merge geometries from different tiles in to a single feature
+						p, err := db.featurePieceFromFeature(ctx, tf.tile, f)
 
-					polys := make([]orb.Polygon, 0)
+						if err != nil {
+							logger.Warn("Failed to derive feature piece", "error", err)
+							continue
+						}
 
-					for _, f2 := range id_features {
+						pieces[id] = append(pieces[id], p)
+					}
 
-						switch f2.Geometry.GeoJSONType() {
-						case "Polygon":
-							polys = append(polys, f2.Geometry.(orb.Polygon))
-						case "MultiPolygon":
+					continue
+				}
 
-							for _, p := range f2.Geometry.(orb.MultiPolygon) {
-								polys = append(polys, p)
-							}
-						default:
-							slog.Warn("Unsupported geometry type for merging", "type", f2.Geometry.GeoJSONType())
-						}
+				// Features wholly contained by a tile which is itself wholly contained by
+				// geom intersect it so there is no need to compare their geometries.
+
+				f_bound := f.Geometry.Bound()
+
+				if !tf.within || !tile_bound.Contains(f_bound.Min) || !tile_bound.Contains(f_bound.Max) {
+
+					intersects, err := geo.Intersects(f.Geometry, geom)
+
+					if err != nil {
+						logger.Error("Failed to determine if feature intersects", "error", err)
+						continue
 					}
 
-					f.Geometry = orb.MultiPolygon(polys)
+					if !intersects {
+						logger.Debug("Feature does not intersect")
+						continue
+					}
 				}
 
 				enc_f, err := f.MarshalJSON()
@@ -178,7 +224,18 @@ func (db *PMTilesSpatialDatabase) IntersectsWithIterator(ctx context.Context, ge
 					return
 				}
 
-				s, err := spr.WhosOnFirstSPR(enc_f)
+				// Expand properties which were stringified when the tile was encoded
+				// so that they can be evaluated by the filters below
+
+				enc_f, err = db.decodeMVT(ctx, enc_f)
+
+				if err != nil {
+					logger.Error("Failed to unfurl MVT for feature", "error", err)
+					yield(nil, err)
+					return
+				}
+
+				s, err := standardPlacesResultFromBody(enc_f)
 
 				if err != nil {
 					logger.Error("Failed to derive SPR for feature", "error", err)
@@ -186,31 +243,85 @@ func (db *PMTilesSpatialDatabase) IntersectsWithIterator(ctx context.Context, ge
 					return
 				}
 
-				if db.enable_feature_cache {
+				if cache_pieces {
+					pieces[id] = append(pieces[id], &featurePiece{tile: tf.tile, body: enc_f})
+				}
+
+				if !matchesFilters(s, filters...) {
+					logger.Debug("Feature does not match filters")
+					matches[id] = false
+					continue
+				}
+
+				matches[id] = true
+
+				if !yield(s, nil) {
+					return
+				}
+			}
+		}
+	}
+}
 
-					wg.Add(1)
+// cacheFeaturePieces assembles the (clipped) features read from different tiles for each ID in 'pieces' and adds
+// the result to the feature cache. 'epoch' is the epoch of the router used to read the tiles.
+func (db *PMTilesSpatialDatabase) cacheFeaturePieces(ctx context.Context, epoch string, pieces map[int64][]*featurePiece) {
 
-					go func(body []byte) {
+	wg := new(sync.WaitGroup)
 
-						defer wg.Done()
+	for id, id_pieces := range pieces {
 
-						// TBD: Append/pass path to cache key here?
+		wg.Add(1)
 
-						_, err := db.cache_manager.CacheFeature(ctx, body)
+		go func(id int64, id_pieces []*featurePiece) {
 
-						if err != nil {
-							logger.Warn("Failed to create new feature cache", "id", s.Id(), "error", err)
-						}
+			defer wg.Done()
 
-					}(enc_f)
-				}
+			err := db.assembleFeature(ctx, epoch, id_pieces...)
 
-				yield(s, nil)
+			if err != nil {
+				slog.Warn("Failed to create new feature cache", "id", id, "error", err)
 			}
-		}
 
-		wg.Wait()
+		}(id, id_pieces)
+	}
+
+	wg.Wait()
+}
+
+// featurePieceFromFeature returns a new `featurePiece` for the (decoded) GeoJSON-encoding of 'f' read from 't'.
+func (db *PMTilesSpatialDatabase) featurePieceFromFeature(ctx context.Context, t maptile.Tile, f *geojson.Feature) (*featurePiece, error) {
+
+	enc_f, err := f.MarshalJSON()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal feature, %w", err)
+	}
+
+	enc_f, err = db.decodeMVT(ctx, enc_f)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unfurl MVT for feature, %w", err)
+	}
+
+	p := &featurePiece{
+		tile: t,
+		body: enc_f,
+	}
+
+	return p, nil
+}
+
+// featureId returns the (WOF) ID of 'f' and a boolean value indicating whether it is valid.
+func featureId(f *geojson.Feature) (int64, bool) {
+
+	v, ok := f.ID.(float64)
+
+	if !ok || v < 0 {
+		return 0, false
 	}
+
+	return int64(v), true
 }
 
 func (db *PMTilesSpatialDatabase) pruneSpatialDatabases(ctx context.Context) {
@@ -242,6 +353,15 @@ func (db *PMTilesSpatialDatabase) pruneSpatialDatabases(ctx context.Context) {
 
 		if db_exists {
 
+			// The database has been retrieved again since it was released so
+			// don't remove it. It will be assigned a new release time the next
+			// time it is released.
+
+			if db.spatial_databases_counter.Count(db_name) > 0 {
+				delete(db.spatial_databases_releaser, db_name)
+				continue
+			}
+
 			if now.Before(t_remove) {
 				continue
 			}
@@ -250,6 +370,7 @@ func (db *PMTilesSpatialDatabase) pruneSpatialDatabases(ctx context.Context) {
 			spatial_db.Disconnect(ctx)
 			delete(db.spatial_databases_cache, db_name)
 			delete(db.spatial_databases_releaser, db_name)
+			db.spatial_databases_lru.Remove(db_name)
 
 			pruned += 1
 		}
@@ -258,18 +379,70 @@ func (db *PMTilesSpatialDatabase) pruneSpatialDatabases(ctx context.Context) {
 	return
 }
 
-func (db *PMTilesSpatialDatabase) releaseSpatialDatabase(ctx context.Context, geom any) {
-
-	var db_name string
+// evictSpatialDatabases removes the least recently used spatial databases which are not currently being
+// queried until the number of databases, and their estimated combined size, no longer exceed the limits
+// defined by the ?max-tile-databases= and ?max-tile-memory= parameters. It is assumed that the caller has
+// acquired a write lock on db.spatial_databases_cache_mutex.
+func (db *PMTilesSpatialDatabase) evictSpatialDatabases(ctx context.Context) {
 
-	switch geom.(type) {
-	case *orb.Point:
-		db_name = db.spatialDatabaseNameFromCoord(ctx, geom.(*orb.Point))
-	default:
-		slog.Warn("Unsupport database release type", "type", fmt.Sprintf("%T", geom))
+	if !db.exceedsSpatialDatabasesLimits() {
 		return
 	}
 
+	logger := slog.Default()
+
+	evicted := 0
+
+	db.spatial_databases_releaser_mutex.Lock()
+	defer db.spatial_databases_releaser_mutex.Unlock()
+
+	for db_name := range db.spatial_databases_lru.Oldest() {
+
+		if !db.exceedsSpatialDatabasesLimits() {
+			break
+		}
+
+		if db.spatial_databases_counter.Count(db_name) > 0 {
+			continue
+		}
+
+		spatial_db, exists := db.spatial_databases_cache[db_name]
+
+		if exists {
+			spatial_db.Disconnect(ctx)
+			delete(db.spatial_databases_cache, db_name)
+		}
+
+		delete(db.spatial_databases_releaser, db_name)
+		db.spatial_databases_lru.Remove(db_name)
+
+		evicted += 1
+	}
+
+	logger.Debug("Evict databases", "evicted", evicted, "count", db.spatial_databases_lru.Len(), "size", db.spatial_databases_lru.Size())
+
+	if db.exceedsSpatialDatabasesLimits() {
+		logger.Warn("Spatial databases exceed limits but all remaining databases are in use", "count", db.spatial_databases_lru.Len(), "size", db.spatial_databases_lru.Size())
+	}
+}
+
+// exceedsSpatialDatabasesLimits reports whether the number of spatial databases, or their estimated combined
+// size, exceeds the limits defined by the ?max-tile-databases= and ?max-tile-memory= parameters.
+func (db *PMTilesSpatialDatabase) exceedsSpatialDatabasesLimits() bool {
+
+	if db.max_spatial_databases > 0 && db.spatial_databases_lru.Len() > db.max_spatial_databases {
+		return true
+	}
+
+	if db.max_spatial_databases_memory > 0 && db.spatial_databases_lru.Size() > db.max_spatial_databases_memory {
+		return true
+	}
+
+	return false
+}
+
+func (db *PMTilesSpatialDatabase) releaseSpatialDatabase(ctx context.Context, db_name string) {
+
 	count := db.spatial_databases_counter.Increment(db_name, -1)
 
 	logger := slog.Default()
@@ -280,6 +453,28 @@ func (db *PMTilesSpatialDatabase) releaseSpatialDatabase(ctx context.Context, ge
 		return
 	}
 
+	// Databases created using a router which has since been replaced (because the manifest
+	// has changed) will never be retrieved again so remove them as soon as they are released.
+
+	if !db.isCurrentSpatialDatabase(db_name) {
+		db.purgeSpatialDatabases(ctx)
+		return
+	}
+
+	// Databases may have been retained beyond the limits defined by ?max-tile-databases=
+	// or ?max-tile-memory= because they were in use at the time so check again now that
+	// this one has been released.
+
+	if db.exceedsSpatialDatabasesLimits() {
+		db.spatial_databases_cache_mutex.Lock()
+		db.evictSpatialDatabases(ctx)
+		db.spatial_databases_cache_mutex.Unlock()
+	}
+
+	if db.spatial_databases_ttl <= 0 {
+		return
+	}
+
 	db.spatial_databases_releaser_mutex.Lock()
 	defer db.spatial_databases_releaser_mutex.Unlock()
 
@@ -302,7 +497,16 @@ func (db *PMTilesSpatialDatabase) releaseSpatialDatabase(ctx context.Context, ge
 
 func (db *PMTilesSpatialDatabase) Disconnect(ctx context.Context) error {
 
-	db.spatial_databases_ticker_done <- true
+	if db.spatial_databases_ticker != nil {
+		db.spatial_databases_ticker.Stop()
+		db.spatial_databases_ticker_done <- true
+	}
+
+	db.stopReloading()
+
+	if db.feature_cache_admin != nil {
+		db.feature_cache_admin.Shutdown(ctx)
+	}
 
 	if db.cache_manager != nil {
 		db.cache_manager.Close()
@@ -315,6 +519,7 @@ func (db *PMTilesSpatialDatabase) Disconnect(ctx context.Context) error {
 		spatial_db.Disconnect(ctx)
 		delete(db.spatial_databases_cache, db_name)
 		delete(db.spatial_databases_releaser, db_name)
+		db.spatial_databases_lru.Remove(db_name)
 	}
 
 	db.spatial_databases_cache_mutex.Unlock()
@@ -323,9 +528,13 @@ func (db *PMTilesSpatialDatabase) Disconnect(ctx context.Context) error {
 	return nil
 }
 
-func (db *PMTilesSpatialDatabase) spatialDatabaseFromTile(ctx context.Context, coord *orb.Point) (database.SpatialDatabase, error) {
+// spatialDatabaseFromTile returns a new spatial database containing the features in the tile for 'coord', read from
+// the PMTiles database that 'r' routes the tile to, along with the estimated size, in bytes, of those features. If the
+// tile cache is enabled the database is reopened from the tile cache, if present, or added to it once created.
+func (db *PMTilesSpatialDatabase) spatialDatabaseFromTile(ctx context.Context, r *tileRouter, coord *orb.Point) (database.SpatialDatabase, int64, error) {
 
-	path := db.tilePathFromCoord(ctx, coord)
+	t := db.mapTileFromCoord(ctx, coord)
+	path := tileKey(t)
 
 	logger := slog.Default()
 	logger = logger.With("path", path)
@@ -336,43 +545,92 @@ func (db *PMTilesSpatialDatabase) spatialDatabaseFromTile(ctx context.Context, c
 		logger.Debug("Time to create database", "time", time.Since(t1))
 	}()
 
-	t := db.mapTileFromCoord(ctx, coord)
+	tile_cache := db.tileCacheForTile(r, t)
+
+	if tile_cache != nil {
+
+		spatial_db, size, exists, err := db.cachedTileSpatialDatabase(ctx, r, t, tile_cache)
+
+		if err != nil {
+			logger.Warn("Failed to read database from tile cache, rebuilding", "error", err)
+		}
 
-	features, err := db.featuresForTile(ctx, t)
+		if exists {
+			return spatial_db, size, nil
+		}
+	}
+
+	features, err := db.featuresForTile(ctx, r, t)
 
 	if err != nil {
 		logger.Error("Failed to derive features for tile", "error", err)
-		return nil, fmt.Errorf("Failed to derive features for tile %s, %w", path, err)
+		return nil, 0, fmt.Errorf("Failed to derive features for tile %s, %w", path, err)
 	}
 
 	logger = logger.With("spatial database uri", db.spatial_database_uri)
 	logger = logger.With("count features", len(features))
 
-	db_uri, err := url.Parse(db.spatial_database_uri)
+	size := estimateFeaturesSize(features)
 
-	if err != nil {
-		return nil, err
+	if db.useNativeTileDatabase() {
+
+		tile_db, err := db.tileSpatialDatabaseFromFeatures(ctx, r, t, features)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if tile_cache != nil {
+
+			var buf bytes.Buffer
+			err := tile_db.encode(&buf)
+
+			if err == nil {
+				err = tile_cache.Set(ctx, uint8(t.Z), t.X, t.Y, tile_cache_native_ext, buf.Bytes())
+			}
+
+			if err != nil {
+				logger.Warn("Failed to write database to tile cache", "error", err)
+			}
+		}
+
+		return tile_db, size, nil
 	}
 
-	db_q := db_uri.Query()
-	dsn := db_q.Get("dsn")
+	// If the tile cache is enabled the database is created in a temporary file which is only
+	// added to the tile cache, and then reopened, once all the features have been indexed.
+
+	tmp_path := ""
+
+	var db_uri string
+
+	if tile_cache != nil {
+
+		tmp_path, err = tile_cache.CreateTemp(uint8(t.Z), t.X, t.Y, tile_cache_sqlite_ext)
+
+		if err != nil {
+			logger.Error("Failed to create temporary database", "error", err)
+			return nil, 0, fmt.Errorf("Failed to create temporary database for tile %s, %w", path, err)
+		}
 
-	if strings.Contains(dsn, "{dbname}") {
+		defer os.Remove(tmp_path)
 
-		dbname := fmt.Sprintf("%d-%d-%d", t.X, t.Y, t.Z)
-		new_dsn := strings.Replace(dsn, "{dbname}", dbname, 1)
+		db_uri, err = db.spatialDatabaseURIWithName(tmp_path)
 
-		db_q.Del("dsn")
-		db_q.Set("dsn", new_dsn)
+	} else {
+		db_uri, err = db.spatialDatabaseURIForTile(ctx, r, t)
+	}
 
-		db_uri.RawQuery = db_q.Encode()
+	if err != nil {
+		logger.Error("Failed to derive spatial database URI", "error", err)
+		return nil, 0, fmt.Errorf("Failed to derive spatial database URI for tile %s, %w", path, err)
 	}
 
-	spatial_db, err := database.NewSpatialDatabase(ctx, db_uri.String())
+	spatial_db, err := database.NewSpatialDatabase(ctx, db_uri)
 
 	if err != nil {
 		logger.Error("Failed to instantiate spatial database", "error", err)
-		return nil, fmt.Errorf("Failed to create spatial database for '%s', %w", db_uri.String(), err)
+		return nil, 0, fmt.Errorf("Failed to create spatial database for '%s', %w", db_uri, err)
 	}
 
 	seen := make(map[string]bool)
@@ -405,7 +663,7 @@ func (db *PMTilesSpatialDatabase) spatialDatabaseFromTile(ctx context.Context, c
 
 		if err != nil {
 			logger.Error("Failed to marshal JSON for feature", "id", id, "index", idx, "error", err)
-			return nil, fmt.Errorf("Failed to marshal JSON for feature %d at offset %d, %w", id, idx, err)
+			return nil, 0, fmt.Errorf("Failed to marshal JSON for feature %d at offset %d, %w", id, idx, err)
 		}
 
 		// START OF to remove once we've finished pruning layer data in featuresForTile
@@ -426,7 +684,27 @@ func (db *PMTilesSpatialDatabase) spatialDatabaseFromTile(ctx context.Context, c
 
 		if err != nil {
 			logger.Error("Failed to unfurl MVT for feature", "id", id, "index", idx, "error", err)
-			return nil, fmt.Errorf("Failed to unfurl MVT for feature %d at offset %d, %w", id, idx, err)
+			return nil, 0, fmt.Errorf("Failed to unfurl MVT for feature %d at offset %d, %w", id, idx, err)
+		}
+
+		// Rings which have collapsed to a line or a point, typically the result of
+		// clipping and simplifying polygons when the tiles were produced, have zero-area
+		// bounding boxes. They can never contain a point and some spatial databases
+		// (notably rtree://) will refuse to index them so they are removed here. Features
+		// without any polygonal geometry are cached but not indexed.
+
+		index_body := body
+
+		index_geom, changed := polygonalGeometry(f.Geometry)
+
+		if index_geom != nil && changed {
+
+			index_body, err = sjson.SetBytes(body, "geometry", geojson.NewGeometry(index_geom))
+
+			if err != nil {
+				logger.Error("Failed to assign polygonal geometry for feature", "id", id, "index", idx, "error", err)
+				return nil, 0, fmt.Errorf("Failed to assign polygonal geometry for feature %d at offset %d, %w", id, idx, err)
+			}
 		}
 
 		if db.enable_feature_cache {
@@ -437,9 +715,7 @@ func (db *PMTilesSpatialDatabase) spatialDatabaseFromTile(ctx context.Context, c
 
 				defer wg.Done()
 
-				// TBD: Append/pass path to cache key here?
-
-				_, err := db.cache_manager.CacheFeature(ctx, body)
+				err := db.assembleFeature(ctx, r.epoch, &featurePiece{tile: t, body: body})
 
 				if err != nil {
 					logger.Warn("Failed to create new feature cache", "path", path, "error", err)
@@ -448,191 +724,710 @@ func (db *PMTilesSpatialDatabase) spatialDatabaseFromTile(ctx context.Context, c
 			}(body)
 		}
 
-		err = spatial_db.IndexFeature(ctx, body)
+		if index_geom == nil {
+			logger.Debug("Feature has no polygonal geometry, skipping", "id", id, "type", f.Geometry.GeoJSONType())
+			continue
+		}
+
+		err = spatial_db.IndexFeature(ctx, index_body)
 
 		if err != nil {
 			logger.Error("Failed to index feature", "id", id, "index", idx, "error", err)
-			return nil, fmt.Errorf("Failed to index feature %d at offset %d, %w", id, idx, err)
+			return nil, 0, fmt.Errorf("Failed to index feature %d at offset %d, %w", id, idx, err)
 		}
 	}
 
 	wg.Wait()
 
-	return spatial_db, nil
-}
+	if tmp_path != "" {
 
-func (db *PMTilesSpatialDatabase) mapTileFromCoord(ctx context.Context, coord *orb.Point) maptile.Tile {
+		err := spatial_db.Disconnect(ctx)
 
-	zoom := uint32(db.zoom)
-	z := maptile.Zoom(zoom)
-	t := maptile.At(*coord, z)
+		if err != nil {
+			logger.Error("Failed to disconnect temporary database", "error", err)
+			return nil, 0, fmt.Errorf("Failed to disconnect temporary database for tile %s, %w", path, err)
+		}
 
-	return t
-}
+		err = tile_cache.Commit(ctx, uint8(t.Z), t.X, t.Y, tile_cache_sqlite_ext, tmp_path)
+
+		if err != nil {
+			logger.Error("Failed to add database to tile cache", "error", err)
+			return nil, 0, fmt.Errorf("Failed to add database for tile %s to tile cache, %w", path, err)
+		}
 
-func (db *PMTilesSpatialDatabase) tilePathFromCoord(ctx context.Context, coord *orb.Point) string {
+		spatial_db, _, exists, err := db.cachedTileSpatialDatabase(ctx, r, t, tile_cache)
 
-	t := db.mapTileFromCoord(ctx, coord)
+		if err != nil {
+			logger.Error("Failed to reopen database from tile cache", "error", err)
+			return nil, 0, fmt.Errorf("Failed to reopen database for tile %s from tile cache, %w", path, err)
+		}
 
-	return fmt.Sprintf("/%s/%d/%d/%d.mvt", db.database, t.Z, t.X, t.Y)
+		if !exists {
+			return nil, 0, fmt.Errorf("Database for tile %s was removed from the tile cache before it could be reopened", path)
+		}
+
+		return spatial_db, size, nil
+	}
+
+	return spatial_db, size, nil
 }
 
-func (db *PMTilesSpatialDatabase) spatialDatabaseNameFromCoord(ctx context.Context, coord *orb.Point) string {
+// tileCacheForTile returns the `TileCache` instance for the PMTiles database that 'r' routes the tile 't' to or nil
+// if the tile cache is not enabled or the tile is not covered by any database.
+func (db *PMTilesSpatialDatabase) tileCacheForTile(r *tileRouter, t maptile.Tile) *TileCache {
 
-	t := db.mapTileFromCoord(ctx, coord)
-	return fmt.Sprintf("%s-%d-%d-%d.db", db.database, t.Z, t.X, t.Y)
+	if db.tile_cache == nil {
+		return nil
+	}
+
+	a := r.archiveForTile(t)
+
+	if a == nil {
+		return nil
+	}
+
+	return db.tile_cache.ForArchive(a.id)
 }
 
-func (db *PMTilesSpatialDatabase) spatialDatabaseFromCoord(ctx context.Context, coord *orb.Point) (database.SpatialDatabase, error) {
+// cachedTileSpatialDatabase returns the spatial database for the tile 't' reopened from 'tile_cache', along with its
+// estimated size in bytes, and a boolean value indicating whether the database was found in the tile cache. The
+// features in native:// databases are used to populate the feature cache, if enabled, but those in sqlite://
+// databases are not.
+func (db *PMTilesSpatialDatabase) cachedTileSpatialDatabase(ctx context.Context, r *tileRouter, t maptile.Tile, tile_cache *TileCache) (database.SpatialDatabase, int64, bool, error) {
 
-	db_name := db.spatialDatabaseNameFromCoord(ctx, coord)
+	if db.useNativeTileDatabase() {
 
-	db.spatial_databases_cache_mutex.Lock()
-	defer db.spatial_databases_cache_mutex.Unlock()
+		body, exists, err := tile_cache.Get(ctx, uint8(t.Z), t.X, t.Y, tile_cache_native_ext)
 
-	v, exists := db.spatial_databases_cache[db_name]
+		if err != nil || !exists {
+			return nil, 0, false, err
+		}
 
-	if exists {
-		db.spatial_databases_counter.Increment(db_name, 1)
-		return v, nil
-	}
+		features, err := decodeTileFeatures(bytes.NewReader(body))
 
-	spatial_db, err := db.spatialDatabaseFromTile(ctx, coord)
+		if err != nil {
+			return nil, 0, false, err
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("Failed to create spatial database, %w", err)
-	}
+		tile_db, err := db.tileSpatialDatabaseFromFeatures(ctx, r, t, features)
 
-	db.spatial_databases_counter.Increment(db_name, 1)
-	db.spatial_databases_cache[db_name] = spatial_db
+		if err != nil {
+			return nil, 0, false, err
+		}
 
-	return spatial_db, nil
-}
+		return tile_db, estimateFeaturesSize(features), true, nil
+	}
 
-func (db *PMTilesSpatialDatabase) featuresFromTilesForGeom(ctx context.Context, geom orb.Geometry) (map[int64][]*geojson.Feature, error) {
+	db_path, exists, err := tile_cache.Open(ctx, uint8(t.Z), t.X, t.Y, tile_cache_sqlite_ext)
 
-	features_table := make(map[int64][]*geojson.Feature)
+	if err != nil || !exists {
+		return nil, 0, false, err
+	}
 
-	zoom := maptile.Zoom(uint32(db.zoom))
-	tiles, err := tilecover.Geometry(geom, zoom)
+	info, err := os.Stat(db_path)
 
 	if err != nil {
-		slog.Error("Failed to derive tile cover", "error", err)
-		return nil, fmt.Errorf("Failed to derive tile cover, %w", err)
+		return nil, 0, false, fmt.Errorf("Failed to stat %s, %w", db_path, err)
 	}
 
-	mu := new(sync.RWMutex)
+	db_uri, err := db.spatialDatabaseURIWithName(db_path)
 
-	done_ch := make(chan bool)
-	err_ch := make(chan error)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("Failed to derive spatial database URI, %w", err)
+	}
 
-	for t, _ := range tiles {
+	spatial_db, err := database.NewSpatialDatabase(ctx, db_uri)
 
-		go func(t maptile.Tile) {
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("Failed to create spatial database for '%s', %w", db_uri, err)
+	}
 
-			defer func() {
-				done_ch <- true
-			}()
+	return spatial_db, info.Size(), true, nil
+}
 
-			features, err := db.featuresForTile(ctx, t)
+// tileSpatialDatabaseFromFeatures returns a new `TileSpatialDatabase` instance containing 'features'. Features are
+// only marshaled to JSON (and decoded) here if the feature cache is enabled.
+func (db *PMTilesSpatialDatabase) tileSpatialDatabaseFromFeatures(ctx context.Context, r *tileRouter, t maptile.Tile, features []*geojson.Feature) (*TileSpatialDatabase, error) {
 
-			if err != nil {
-				slog.Error("Failed to derive features for tile", "error", err)
-				err_ch <- err
-				return
-			}
+	logger := slog.Default()
+	logger = logger.With("tile", tileKey(t))
 
-			seen := new(sync.Map)
+	tile_db := newTileSpatialDatabase()
+	tile_db.decode_func = db.decodeMVT
 
-			for _, f := range features {
+	seen := make(map[string]bool)
 
-				id := int64(f.ID.(float64))
+	wg := new(sync.WaitGroup)
 
-				if id < 0 {
-					slog.Warn("Unexpected WOF ID", "raw", f.ID)
-					continue
-				}
+	for idx, f := range features {
 
-				// Skip if we've seen this ID in this tile
+		id, err := tileFeatureId(f)
 
-				_, exists := seen.LoadOrStore(id, true)
+		if err != nil {
+			logger.Error("Failed to derive ID for feature", "index", idx, "error", err)
+			return nil, fmt.Errorf("Failed to derive ID for feature at offset %d, %w", idx, err)
+		}
 
-				if exists {
-					continue
-				}
+		_, ok := seen[id]
 
-				// Combine common features seen across (spanning) tiles
+		if ok {
+			continue
+		}
 
-				mu.Lock()
+		seen[id] = true
 
-				features_list, exists := features_table[id]
+		var body []byte
 
-				if !exists {
-					features_list = make([]*geojson.Feature, 0)
-				}
+		if db.enable_feature_cache {
 
-				features_list = append(features_list, f)
-				features_table[id] = features_list
+			enc, err := f.MarshalJSON()
 
-				mu.Unlock()
+			if err != nil {
+				logger.Error("Failed to marshal JSON for feature", "id", id, "index", idx, "error", err)
+				return nil, fmt.Errorf("Failed to marshal JSON for feature %s at offset %d, %w", id, idx, err)
 			}
-		}(t)
-
-	}
 
-	remaining := len(tiles)
+			body, err = db.decodeMVT(ctx, enc)
+
+			if err != nil {
+				logger.Error("Failed to unfurl MVT for feature", "id", id, "index", idx, "error", err)
+				return nil, fmt.Errorf("Failed to unfurl MVT for feature %s at offset %d, %w", id, idx, err)
+			}
+
+			wg.Add(1)
+
+			go func(body []byte) {
+
+				defer wg.Done()
+
+				err := db.assembleFeature(ctx, r.epoch, &featurePiece{tile: t, body: body})
+
+				if err != nil {
+					logger.Warn("Failed to create new feature cache", "id", id, "error", err)
+				}
+
+			}(body)
+		}
+
+		err = tile_db.indexGeoJSONFeature(ctx, f, body)
+
+		if err != nil {
+			logger.Error("Failed to index feature", "id", id, "index", idx, "error", err)
+			return nil, fmt.Errorf("Failed to index feature %s at offset %d, %w", id, idx, err)
+		}
+	}
+
+	wg.Wait()
+
+	return tile_db, nil
+}
+
+// useNativeTileDatabase reports whether features in individual tiles are indexed using a `TileSpatialDatabase` instance.
+func (db *PMTilesSpatialDatabase) useNativeTileDatabase() bool {
+	return strings.HasPrefix(db.spatial_database_uri, fmt.Sprintf("%s://", NATIVE_TILE_DATABASE_SCHEME))
+}
+
+// spatialDatabaseURIForTile returns the URI used to create the spatial database for the features in 't'. Any
+// occurrences of the string "{dbname}" in the host, path or query parameters of the tile database URI are replaced
+// with a name derived from 't', the generation of 'r' and the namespace unique to 'db'.
+func (db *PMTilesSpatialDatabase) spatialDatabaseURIForTile(ctx context.Context, r *tileRouter, t maptile.Tile) (string, error) {
+	dbname := fmt.Sprintf("%s_g%d-%d-%d-%d", db.spatial_database_namespace, r.generation, t.X, t.Y, t.Z)
+	return db.spatialDatabaseURIWithName(dbname)
+}
+
+// spatialDatabaseURIWithName returns the URI used to create a spatial database with any occurrences of the string
+// "{dbname}" in the host, path or query parameters of the tile database URI replaced with 'dbname'.
+func (db *PMTilesSpatialDatabase) spatialDatabaseURIWithName(dbname string) (string, error) {
+
+	if !strings.Contains(db.spatial_database_uri, "{dbname}") {
+		return db.spatial_database_uri, nil
+	}
+
+	db_uri, err := url.Parse(db.spatial_database_uri)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse spatial database URI, %w", err)
+	}
+
+	db_uri.Host = strings.Replace(db_uri.Host, "{dbname}", dbname, -1)
+	db_uri.Path = strings.Replace(db_uri.Path, "{dbname}", dbname, -1)
+
+	db_q := db_uri.Query()
+
+	for k, values := range db_q {
+
+		for i, v := range values {
+			values[i] = strings.Replace(v, "{dbname}", dbname, -1)
+		}
+
+		db_q[k] = values
+	}
+
+	db_uri.RawQuery = db_q.Encode()
+
+	return db_uri.String(), nil
+}
+
+func (db *PMTilesSpatialDatabase) mapTileFromCoord(ctx context.Context, coord *orb.Point) maptile.Tile {
+
+	zoom := uint32(db.zoom)
+	z := maptile.Zoom(zoom)
+	t := maptile.At(*coord, z)
+
+	return t
+}
+
+// spatialDatabaseName returns the name of the spatial database for the tile 't' created using 'r'.
+func spatialDatabaseName(r *tileRouter, t maptile.Tile) string {
+	return fmt.Sprintf("g%d-%d-%d-%d.db", r.generation, t.Z, t.X, t.Y)
+}
+
+// isCurrentSpatialDatabase reports whether the spatial database named 'db_name' was created using the current router.
+func (db *PMTilesSpatialDatabase) isCurrentSpatialDatabase(db_name string) bool {
+	r := db.router.Load()
+	return strings.HasPrefix(db_name, fmt.Sprintf("g%d-", r.generation))
+}
+
+// purgeSpatialDatabases removes the spatial databases, created using a router which has since been replaced, that
+// are not currently being queried.
+func (db *PMTilesSpatialDatabase) purgeSpatialDatabases(ctx context.Context) {
+
+	db.spatial_databases_cache_mutex.Lock()
+	db.spatial_databases_releaser_mutex.Lock()
+
+	defer func() {
+		db.spatial_databases_cache_mutex.Unlock()
+		db.spatial_databases_releaser_mutex.Unlock()
+	}()
+
+	purged := 0
+
+	for db_name, spatial_db := range db.spatial_databases_cache {
+
+		if db.isCurrentSpatialDatabase(db_name) {
+			continue
+		}
+
+		if db.spatial_databases_counter.Count(db_name) > 0 {
+			continue
+		}
+
+		spatial_db.Disconnect(ctx)
+		delete(db.spatial_databases_cache, db_name)
+		delete(db.spatial_databases_releaser, db_name)
+		db.spatial_databases_lru.Remove(db_name)
+
+		purged += 1
+	}
+
+	// Likewise the reference counts for databases created using a replaced router will never
+	// be incremented again (once they reach zero) so remove them rather than letting them
+	// accumulate every time the databases are reloaded.
+
+	db.spatial_databases_counter.Prune(func(db_name string) bool {
+		return !db.isCurrentSpatialDatabase(db_name)
+	})
+
+	if purged > 0 {
+		slog.Debug("Purge databases", "purged", purged)
+	}
+}
+
+// tileDatabaseBuild tracks the creation of a spatial database for a single tile so that concurrent
+// requests for the same tile wait on a single build rather than each creating their own database.
+type tileDatabaseBuild struct {
+	// done is closed once the build has completed (successfully or not).
+	done chan bool
+	// waiters is the number of requests, other than the one performing the build, waiting on the result.
+	waiters int32
+	db      database.SpatialDatabase
+	err     error
+}
+
+// spatialDatabaseFromCoord returns the spatial database containing the features in the tile for 'coord', creating it
+// using 'r' if necessary, along with its name. The database's reference count is incremented and callers are expected to
+// release it, using its name, once they have finished querying it.
+func (db *PMTilesSpatialDatabase) spatialDatabaseFromCoord(ctx context.Context, r *tileRouter, coord *orb.Point) (database.SpatialDatabase, string, error) {
+
+	db_name := spatialDatabaseName(r, db.mapTileFromCoord(ctx, coord))
+
+	// Note the use of read locks. Once a database has been created and cached any number of
+	// requests can retrieve it at the same time. The write lock, which is also used when pruning
+	// databases, is only held long enough to add a newly created database to the cache.
+
+	spatial_db, exists := db.cachedSpatialDatabase(db_name)
+
+	if exists {
+		return spatial_db, db_name, nil
+	}
+
+	db.spatial_databases_inflight_mutex.Lock()
+
+	// Check the cache again in case the database was created between the first check and
+	// acquiring the lock for in-flight builds.
+
+	spatial_db, exists = db.cachedSpatialDatabase(db_name)
+
+	if exists {
+		db.spatial_databases_inflight_mutex.Unlock()
+		return spatial_db, db_name, nil
+	}
+
+	b, building := db.spatial_databases_inflight[db_name]
+
+	if building {
+
+		b.waiters += 1
+		db.spatial_databases_inflight_mutex.Unlock()
 
-	for remaining > 0 {
 		select {
-		case <-done_ch:
-			remaining -= 1
-		case err := <-err_ch:
-			return nil, err
+		case <-b.done:
+			// pass
+		case <-ctx.Done():
+
+			// If the build completed at the same time the context was cancelled then this
+			// request has already been accounted for in the database's reference count.
+
+			counted := false
+
+			db.spatial_databases_inflight_mutex.Lock()
+
+			select {
+			case <-b.done:
+				counted = b.err == nil
+			default:
+				b.waiters -= 1
+			}
+
+			db.spatial_databases_inflight_mutex.Unlock()
+
+			if counted {
+				db.releaseSpatialDatabase(ctx, db_name)
+			}
+
+			return nil, "", ctx.Err()
+		}
+
+		if b.err != nil {
+			return nil, "", fmt.Errorf("Failed to create spatial database, %w", b.err)
 		}
+
+		return b.db, db_name, nil
+	}
+
+	b = &tileDatabaseBuild{
+		done: make(chan bool),
+	}
+
+	db.spatial_databases_inflight[db_name] = b
+	db.spatial_databases_inflight_mutex.Unlock()
+
+	// The database is shared by every request waiting on it so don't let the cancellation
+	// of this request cause the build to fail for everyone else.
+
+	build_ctx := context.WithoutCancel(ctx)
+
+	spatial_db, size, err := db.spatialDatabaseFromTile(build_ctx, r, coord)
+
+	db.spatial_databases_inflight_mutex.Lock()
+
+	b.db = spatial_db
+	b.err = err
+
+	if err == nil {
+		db.spatial_databases_cache_mutex.Lock()
+		db.spatial_databases_counter.Increment(db_name, 1+b.waiters)
+		db.spatial_databases_cache[db_name] = spatial_db
+		db.spatial_databases_lru.Add(db_name, size)
+		db.evictSpatialDatabases(ctx)
+		db.spatial_databases_cache_mutex.Unlock()
+	}
+
+	delete(db.spatial_databases_inflight, db_name)
+	close(b.done)
+
+	db.spatial_databases_inflight_mutex.Unlock()
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to create spatial database, %w", err)
+	}
+
+	return spatial_db, db_name, nil
+}
+
+// cachedSpatialDatabase returns the spatial database named 'db_name', incrementing its reference count, if it
+// has already been created.
+func (db *PMTilesSpatialDatabase) cachedSpatialDatabase(db_name string) (database.SpatialDatabase, bool) {
+
+	db.spatial_databases_cache_mutex.RLock()
+	defer db.spatial_databases_cache_mutex.RUnlock()
+
+	v, exists := db.spatial_databases_cache[db_name]
+
+	if !exists {
+		return nil, false
 	}
 
-	return features_table, nil
+	db.spatial_databases_counter.Increment(db_name, 1)
+	db.spatial_databases_lru.Touch(db_name)
+
+	return v, true
 }
 
-func (db *PMTilesSpatialDatabase) featuresForTile(ctx context.Context, t maptile.Tile) ([]*geojson.Feature, error) {
+// estimateFeaturesSize returns a rough estimate, in bytes, of the memory used to store 'features'. It is
+// only meant to be used to compare the relative sizes of spatial databases against one another.
+func estimateFeaturesSize(features []*geojson.Feature) int64 {
+
+	size := int64(0)
+
+	for _, f := range features {
 
-	path := fmt.Sprintf("/%s/%d/%d/%d.mvt", db.database, t.Z, t.X, t.Y)
+		// Allow for the feature itself, its SPR and its (prepared) geometry
+
+		size += 512
+
+		if f.Geometry != nil {
+			size += int64(geometryPointCount(f.Geometry)) * 16
+		}
+
+		for k, v := range f.Properties {
+
+			size += int64(len(k)) + 16
+
+			str_v, ok := v.(string)
+
+			if ok {
+				size += int64(len(str_v))
+			}
+		}
+	}
+
+	return size
+}
+
+// tileFeatures is the list of features read from a tile.
+type tileFeatures struct {
+	tile     maptile.Tile
+	features []*geojson.Feature
+	// Whether the tile is wholly contained by the geometry being queried.
+	within bool
+}
+
+// featuresFromTilesForGeom returns an iterator of the features in each of the tiles, derived by tilesForGeom,
+// covering 'geom' read using 'router'. The features for each tile are yielded as soon as they have been read, in no
+// particular order. At most db.tile_fetch_concurrency tiles are read at the same time. If the iterator's yield
+// function returns false, an error is encountered or 'ctx' is cancelled then any outstanding reads are cancelled
+// and the iterator does not return until they have completed.
+func (db *PMTilesSpatialDatabase) featuresFromTilesForGeom(ctx context.Context, router *tileRouter, geom orb.Geometry) iter.Seq2[*tileFeatures, error] {
+
+	return func(yield func(*tileFeatures, error) bool) {
+
+		tiles, err := db.tilesForGeom(ctx, geom)
+
+		if err != nil {
+			slog.Error("Failed to derive tile cover", "error", err)
+			yield(nil, err)
+			return
+		}
+
+		type tileResult struct {
+			features *tileFeatures
+			err      error
+		}
+
+		// Tiles are read by a fixed number of workers, defined by the ?tile-fetch-concurrency=
+		// parameter, rather than starting a goroutine for every tile. Cancelling tiles_ctx
+		// (when this function returns) stops the workers, and the goroutine feeding them
+		// tiles, and the deferred wg.Wait ensures none of them are left behind.
+
+		tiles_ctx, tiles_cancel := context.WithCancel(ctx)
+
+		tiles_ch := make(chan maptile.Tile)
+		results_ch := make(chan *tileResult)
+
+		wg := new(sync.WaitGroup)
+
+		defer func() {
+			tiles_cancel()
+			wg.Wait()
+		}()
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+			defer close(tiles_ch)
+
+			for t := range tiles {
+
+				select {
+				case tiles_ch <- t:
+					// pass
+				case <-tiles_ctx.Done():
+					return
+				}
+			}
+		}()
+
+		workers := min(db.tile_fetch_concurrency, len(tiles))
+
+		for i := 0; i < workers; i++ {
+
+			wg.Add(1)
+
+			go func() {
+
+				defer wg.Done()
+
+				for t := range tiles_ch {
+
+					done := db.startTileFetch()
+					features, err := db.featuresForTile(tiles_ctx, router, t)
+					done()
+
+					if err != nil {
+						slog.Error("Failed to derive features for tile", "error", err)
+					}
+
+					r := &tileResult{
+						features: &tileFeatures{
+							tile:     t,
+							features: features,
+							within:   tiles[t],
+						},
+						err: err,
+					}
+
+					select {
+					case results_ch <- r:
+						// pass
+					case <-tiles_ctx.Done():
+						return
+					}
+				}
+			}()
+		}
+
+		for remaining := len(tiles); remaining > 0; remaining-- {
+
+			// Check for cancellation first since select chooses randomly between
+			// channels which are ready.
+
+			err := ctx.Err()
+
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			case r := <-results_ch:
+
+				if r.err != nil {
+					yield(nil, r.err)
+					return
+				}
+
+				if !yield(r.features, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// startTileFetch records that a tile is being read by featuresFromTilesForGeom, updating the total number of tiles
+// read and the largest number of tiles read at the same time, and returns a function to be invoked once the tile has
+// been read.
+func (db *PMTilesSpatialDatabase) startTileFetch() func() {
+
+	db.tile_fetches_total.Add(1)
+
+	n := db.tile_fetches.Add(1)
+
+	for {
+
+		peak := db.tile_fetches_peak.Load()
+
+		if n <= peak || db.tile_fetches_peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+
+	return func() {
+		db.tile_fetches.Add(-1)
+	}
+}
+
+// featuresForTile returns the features in the database layer for the tile 't', read from the PMTiles database that
+// 'r' routes the tile to. If 't' is at a zoom level greater
+// than the maximum zoom level at which tiles are stored in the PMTiles database then features are read from the
+// ancestor of 't' at that zoom level and clipped to the bounds of 't' (overzooming). If ?overzoom-missing-tiles=
+// is enabled the same is done, using the nearest ancestor which exists, for tiles missing from the database.
+// Either way the features returned are those of 't' so the per-tile spatial databases, and feature pieces, derived
+// from them are the same regardless of the zoom levels at which the PMTiles database was built.
+func (db *PMTilesSpatialDatabase) featuresForTile(ctx context.Context, r *tileRouter, t maptile.Tile) ([]*geojson.Feature, error) {
+
+	a := r.archiveForTile(t)
+
+	if a == nil {
+		slog.Debug("Tile is not covered by any database", "tile", tileKey(t))
+		return make([]*geojson.Feature, 0), nil
+	}
+
+	source := t
+
+	for int(source.Z) > a.max_zoom {
+		source = source.Parent()
+	}
+
+	path := fmt.Sprintf("/%s/%d/%d/%d.mvt", a.name, source.Z, source.X, source.Y)
 
 	// It's tempting to cache body (or the resultant FeatureCollection) here. Ancedotally
 	// at zoom level 12 it's very easy to blow past the 400kb size limit for items in DynamoDB.
 	// So, in an AWS context, we could write tile caches to a gocloud.dev/blob instance but
 	// will that read really be faster than reading from the PMTiles database also in S3? Maybe?
 
-	server_ctx, server_cancel := context.WithTimeout(ctx, 3*time.Second)
-	defer server_cancel()
-
-	status_code, _, body := db.server.Get(server_ctx, path)
+	status_code, body, err := db.tileData(ctx, a, source)
 
-	var features []*geojson.Feature
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get %s, %w", path, err)
+	}
 
-	switch status_code {
+	for status_code == 204 && db.overzoom_missing_tiles && int(source.Z) > a.min_zoom {
 
-	case 200:
+		source = source.Parent()
+		path = fmt.Sprintf("/%s/%d/%d/%d.mvt", a.name, source.Z, source.X, source.Y)
 
-		layers, err := mvt.UnmarshalGzipped(body)
+		status_code, body, err = db.tileData(ctx, a, source)
 
 		if err != nil {
-			return nil, fmt.Errorf("Failed to unmarshal tile, %w", err)
+			return nil, fmt.Errorf("Failed to get %s, %w", path, err)
 		}
+	}
 
-		// Prune layers here
+	var features []*geojson.Feature
 
-		layers.ProjectToWGS84(t)
+	switch status_code {
 
-		fc := layers.ToFeatureCollections()
+	case 200:
 
-		_, exists := fc[db.layer]
+		layers, err := UnmarshalTile(ctx, a.header.TileCompression, body)
 
-		if !exists {
-			return nil, fmt.Errorf("Missing %s layer", db.layer)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to unmarshal %s, %w", path, err)
 		}
 
-		features = fc[db.layer].Features
+		features = featuresForLayers(source, layers, db.layers)
+
+		if source != t {
+			slog.Debug("Overzoom tile", "tile", fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y), "source", path)
+			features = clipFeaturesToTile(t, features)
+		}
 
 	case 204:
 
@@ -640,64 +1435,126 @@ func (db *PMTilesSpatialDatabase) featuresForTile(ctx context.Context, t maptile
 		// https://github.com/protomaps/go-pmtiles/blob/0ac8f97530b3367142cfd250585d60936d0ce643/pmtiles/loop.go#L296
 
 		features = make([]*geojson.Feature, 0)
-	default:
-		return nil, fmt.Errorf("Failed to get %s, unexpected status code %d", path, status_code)
 	}
 
 	return features, nil
 }
 
-// Expand WOF values that were stringified in the process of encoding them as MVT. Customs decoders are not yet supported.
-// https://docs.mapbox.com/data/tilesets/guides/vector-tiles-standards/#how-to-encode-attributes-that-arent-strings-or-numbers
-func (db *PMTilesSpatialDatabase) decodeMVT(ctx context.Context, body []byte) ([]byte, error) {
+// featuresForLayers returns the features in 'layers', read from the tile 't', which belong to any of the layers
+// named in 'names'. Layers missing from the tile are ignored. If a feature, identified by its "wof:id" and
+// "src:alt_label" properties, appears in more than one layer then only the feature in the layer listed first
+// in 'names' is returned.
+func featuresForLayers(t maptile.Tile, layers mvt.Layers, names []string) []*geojson.Feature {
+
+	// Only project (and convert) the layers we care about
 
-	props := gjson.GetBytes(body, "properties")
+	by_name := make(map[string]*mvt.Layer)
 
-	if !props.Exists() {
-		return body, nil
+	for _, l := range layers {
+
+		if slices.Contains(names, l.Name) {
+			by_name[l.Name] = l
+		}
 	}
 
-	for k, v := range props.Map() {
+	features := make([]*geojson.Feature, 0)
+	seen := make(map[string]bool)
 
-		switch k {
-		case "wof:superseded_by", "wof:supersedes", "wof:belongsto":
+	for _, name := range names {
 
-			var values []int64
+		l, exists := by_name[name]
 
-			err := json.Unmarshal([]byte(v.String()), &values)
+		if !exists {
+			continue
+		}
 
-			if err != nil {
-				return nil, fmt.Errorf("Failed to unmarshal %s value (%s), %w", k, v.String(), err)
-			}
+		l.ProjectToWGS84(t)
 
-			path := fmt.Sprintf("properties.%s", k)
-			body, err = sjson.SetBytes(body, path, values)
+		for _, f := range l.Features {
 
-			if err != nil {
-				return nil, fmt.Errorf("Failed to set %s, %w", path, err)
-			}
+			if len(names) > 1 {
 
-		case "wof:hierarchy":
+				id, err := tileFeatureId(f)
 
-			var values []map[string]int64
+				if err == nil {
 
-			err := json.Unmarshal([]byte(v.String()), &values)
+					if seen[id] {
+						continue
+					}
 
-			if err != nil {
-				return nil, fmt.Errorf("Failed to unmarshal %s value (%s), %w", k, v.String(), err)
+					seen[id] = true
+				}
 			}
 
-			path := fmt.Sprintf("properties.%s", k)
-			body, err = sjson.SetBytes(body, path, values)
+			features = append(features, f)
+		}
+	}
 
-			if err != nil {
-				return nil, fmt.Errorf("Failed to set %s, %w", path, err)
-			}
+	return features
+}
 
-		default:
-			// pass
+// clipFeaturesToTile returns the features in 'features', read from an ancestor of 't', clipped to the bounds of 't'.
+// Features which do not intersect 't' are omitted. The geometries of 'features' are replaced in place.
+func clipFeaturesToTile(t maptile.Tile, features []*geojson.Feature) []*geojson.Feature {
+
+	bound := t.Bound()
+	clipped := make([]*geojson.Feature, 0, len(features))
+
+	for _, f := range features {
+
+		if f.Geometry == nil || !f.Geometry.Bound().Intersects(bound) {
+			continue
+		}
+
+		g := clip.Geometry(bound, f.Geometry)
+
+		if g == nil {
+			continue
 		}
+
+		f.Geometry = g
+		clipped = append(clipped, f)
+	}
+
+	return clipped
+}
+
+// tileData returns the HTTP status code, either 200 or 204 (the tile does not exist), and body for the tile 't' read
+// from the PMTiles database 'a'. If 'ctx' is cancelled while the tile is being read its error is returned.
+func (db *PMTilesSpatialDatabase) tileData(ctx context.Context, a *tileArchive, t maptile.Tile) (int, []byte, error) {
+
+	path := fmt.Sprintf("/%s/%d/%d/%d.mvt", a.name, t.Z, t.X, t.Y)
+
+	server_ctx, server_cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer server_cancel()
+
+	status_code, _, body := db.server.Get(server_ctx, path)
+
+	// The server reports all failures, including cancelled requests, as HTTP status codes
+	// so check the contexts to tell a cancellation or a timeout apart from other errors.
+
+	err := ctx.Err()
+
+	if err != nil {
+		return 0, nil, err
 	}
 
-	return body, nil
+	err = server_ctx.Err()
+
+	if err != nil {
+		return 0, nil, fmt.Errorf("Failed to read tile, %w", err)
+	}
+
+	switch status_code {
+	case 200, 204:
+		return status_code, body, nil
+	default:
+		return status_code, nil, fmt.Errorf("Unexpected status code %d, %s", status_code, strings.TrimSpace(string(body)))
+	}
+}
+
+// Expand WOF values that were stringified in the process of encoding them as MVT using the property decoders
+// registered with `RegisterPropertyDecoder`.
+func (db *PMTilesSpatialDatabase) decodeMVT(ctx context.Context, body []byte) ([]byte, error) {
+	return decodeProperties(ctx, body, db.decode_json_properties)
 }
@@ -3,6 +3,7 @@ package pmtiles
 // Implement the whosonfirst/go-reader/v2.Reader interface.
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -39,7 +40,22 @@ func (db *PMTilesSpatialDatabase) Read(ctx context.Context, path string) (io.Rea
 		return nil, fmt.Errorf("Failed to read feature from cache for %s, %w", path, err)
 	}
 
-	r := strings.NewReader(fc.Body)
+	// Features cached from databases which have since been replaced are stale
+
+	if !isCurrentFeatureCache(fc, db.router.Load().epoch) {
+		return nil, spatial.ErrNotFound
+	}
+
+	// Remove the properties used to assemble the feature from the clipped versions in
+	// individual tiles and flag features which have only been partially assembled.
+
+	body, err := finalizeAssembledFeature([]byte(fc.Body))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to finalize feature for %s, %w", path, err)
+	}
+
+	r := bytes.NewReader(body)
 
 	rsc, err := ioutil.NewReadSeekCloser(r)
 
@@ -70,13 +86,13 @@ func (db *PMTilesSpatialDatabase) Exists(ctx context.Context, path string) (bool
 
 	fname = strings.Replace(fname, ".geojson", "", 1)
 
-	_, err = db.cache_manager.GetFeatureCache(ctx, fname)
+	fc, err := db.cache_manager.GetFeatureCache(ctx, fname)
 
 	if err != nil {
 		return false, nil
 	}
 
-	return true, nil
+	return isCurrentFeatureCache(fc, db.router.Load().epoch), nil
 }
 
 func (db *PMTilesSpatialDatabase) ReaderURI(ctx context.Context, path string) string {
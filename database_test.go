@@ -2,21 +2,32 @@ package pmtiles
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	_ "log/slog"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/mvt"
 	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/whosonfirst/go-whosonfirst-spatial-pmtiles/cache"
 	"github.com/whosonfirst/go-whosonfirst-spatial/database"
 	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
 )
 
-func TestDatabase(t *testing.T) {
+// testFixturePath returns the absolute path of the fixtures/sf.pmtiles database. The test is skipped if the fixture
+// is only a Git LFS pointer, rather than the database itself, because Git LFS is not installed.
+func testFixturePath(t *testing.T) string {
+
+	t.Helper()
 
 	rel_path := "fixtures/sf.pmtiles"
 	abs_path, err := filepath.Abs(rel_path)
@@ -25,43 +36,92 @@ func TestDatabase(t *testing.T) {
 		t.Fatalf("Failed to derive absolute path for %s, %v", rel_path, err)
 	}
 
+	r, err := os.Open(abs_path)
+
+	if err != nil {
+		t.Fatalf("Failed to open %s, %v", abs_path, err)
+	}
+
+	defer r.Close()
+
+	prefix := make([]byte, len(lfs_pointer_prefix))
+	_, err = io.ReadFull(r, prefix)
+
+	if err == nil && string(prefix) == lfs_pointer_prefix {
+		t.Skipf("%s is a Git LFS pointer, skipping", rel_path)
+	}
+
+	return abs_path
+}
+
+// lfs_pointer_prefix is the string that Git LFS pointer files start with.
+const lfs_pointer_prefix string = "version https://git-lfs.github.com/spec/"
+
+// testDatabaseURI returns the URI for a `PMTilesSpatialDatabase` instance reading the fixtures/sf.pmtiles database
+// with the (encoded) query parameters in 'params' appended.
+func testDatabaseURI(t *testing.T, params string) string {
+
+	t.Helper()
+
+	abs_path := testFixturePath(t)
+
 	root := filepath.Dir(abs_path)
-	fname := filepath.Base(abs_path)
+	fname := strings.Replace(filepath.Base(abs_path), ".pmtiles", "", 1)
+
+	db_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&database=%s", root, fname)
+
+	if params != "" {
+		db_uri = fmt.Sprintf("%s&%s", db_uri, params)
+	}
+
+	return db_uri
+}
 
-	fname = strings.Replace(fname, ".pmtiles", "", 1)
+// newTestDatabase returns a new `PMTilesSpatialDatabase` instance, created using the URI returned by
+// `testDatabaseURI`, which is disconnected when the test completes.
+func newTestDatabase(t *testing.T, params string) *PMTilesSpatialDatabase {
 
-	db_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&database=%s&zoom=13&enable_cache=true&layer=whosonfirst", root, fname)
+	t.Helper()
 
 	ctx := context.Background()
+	db_uri := testDatabaseURI(t, params)
 
-	db, err := database.NewSpatialDatabase(ctx, db_uri)
+	db, err := NewPMTilesSpatialDatabase(ctx, db_uri)
 
 	if err != nil {
 		t.Fatalf("Failed to create new spatial database for %s, %v", db_uri, err)
 	}
 
-	err = db.Disconnect(ctx)
+	t.Cleanup(func() {
+		db.Disconnect(ctx)
+	})
 
-	if err != nil {
-		t.Fatalf("Failed to disconnect database, %v", err)
-	}
+	return db.(*PMTilesSpatialDatabase)
 }
 
-func TestPointInPolygon(t *testing.T) {
+// readFixtureFeature returns the feature read from the GeoJSON file 'path'.
+func readFixtureFeature(t *testing.T, path string) *geojson.Feature {
 
-	rel_path := "fixtures/sf.pmtiles"
-	abs_path, err := filepath.Abs(rel_path)
+	t.Helper()
+
+	body, err := os.ReadFile(path)
 
 	if err != nil {
-		t.Fatalf("Failed to derive absolute path for %s, %v", rel_path, err)
+		t.Fatalf("Failed to read %s, %v", path, err)
 	}
 
-	root := filepath.Dir(abs_path)
-	fname := filepath.Base(abs_path)
+	f, err := geojson.UnmarshalFeature(body)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal %s, %v", path, err)
+	}
+
+	return f
+}
 
-	fname = strings.Replace(fname, ".pmtiles", "", 1)
+func TestDatabase(t *testing.T) {
 
-	db_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&database=%s&zoom=13&enable_cache=true&layer=whosonfirst", root, fname)
+	db_uri := testDatabaseURI(t, "zoom=13&enable_cache=true&layer=whosonfirst")
 
 	ctx := context.Background()
 
@@ -71,7 +131,88 @@ func TestPointInPolygon(t *testing.T) {
 		t.Fatalf("Failed to create new spatial database for %s, %v", db_uri, err)
 	}
 
-	defer db.Disconnect(ctx)
+	err = db.Disconnect(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to disconnect database, %v", err)
+	}
+}
+
+func TestDatabaseConfiguration(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Zoom and layer should be derived from the database when omitted
+
+	pmtiles_db := newTestDatabase(t, "")
+
+	r := pmtiles_db.router.Load()
+
+	if r.primary == nil {
+		t.Fatalf("Expected router to have a primary database")
+	}
+
+	header := r.primary.header
+
+	if pmtiles_db.zoom != int(header.MaxZoom) {
+		t.Fatalf("Unexpected zoom (%d), expected %d", pmtiles_db.zoom, header.MaxZoom)
+	}
+
+	if len(pmtiles_db.layers) == 0 {
+		t.Fatalf("Expected layer to be derived from database")
+	}
+
+	// The feature cache should be configurable
+
+	cache_db := newTestDatabase(t, fmt.Sprintf("enable-cache=true&feature-cache-uri=%s&cache-ttl=60", url.QueryEscape("mem://pmtiles_features/{key}")))
+
+	cache_manager, enabled := cache_db.FeatureCache()
+
+	if !enabled {
+		t.Fatalf("Expected feature cache to be enabled")
+	}
+
+	_, ok := cache_manager.(*cache.DocstoreCacheManager)
+
+	if !ok {
+		t.Fatalf("Expected feature cache to use a docstore cache manager")
+	}
+
+	_, enabled = pmtiles_db.FeatureCache()
+
+	if enabled {
+		t.Fatalf("Expected feature cache to be disabled")
+	}
+
+	// Configuration errors should be reported when the database is created
+
+	invalid := []string{
+		strings.Replace(testDatabaseURI(t, ""), "database=sf", "database=bogus", 1),
+		testDatabaseURI(t, "layer=bogus"),
+		testDatabaseURI(t, fmt.Sprintf("enable-cache=true&feature-cache-uri=%s", url.QueryEscape("bogus://"))),
+		testDatabaseURI(t, "enable-cache=true&cache-ttl=0"),
+		testDatabaseURI(t, "feature-cache-admin-address=localhost:0"),
+	}
+
+	if header.MinZoom > 0 {
+		invalid = append(invalid, testDatabaseURI(t, fmt.Sprintf("zoom=%d", header.MinZoom-1)))
+	}
+
+	for _, uri := range invalid {
+
+		_, err := NewPMTilesSpatialDatabase(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", uri)
+		}
+	}
+}
+
+func TestPointInPolygon(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&enable_cache=true&layer=whosonfirst")
+
+	ctx := context.Background()
 
 	lat := 37.759415
 	lon := -122.414647
@@ -116,24 +257,129 @@ func TestPointInPolygon(t *testing.T) {
 	*/
 }
 
-func TestIntersects(t *testing.T) {
+func TestPointInPolygonOverzoom(t *testing.T) {
 
-	rel_path := "fixtures/sf.pmtiles"
-	abs_path, err := filepath.Abs(rel_path)
+	ctx := context.Background()
+
+	pt := orb.Point{-122.414647, 37.759415}
+
+	pip := func(params string) []string {
+
+		db := newTestDatabase(t, params)
+
+		rsp, err := db.PointInPolygon(ctx, &pt)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		ids := make([]string, 0)
+
+		for _, r := range rsp.Results() {
+			ids = append(ids, r.Id())
+		}
+
+		slices.Sort(ids)
+		return ids
+	}
+
+	// Querying at a zoom level greater than the maximum zoom of the database should
+	// return the same results as querying at the maximum zoom
+
+	expected := pip("layer=whosonfirst")
+
+	if len(expected) == 0 {
+		t.Fatalf("Expected results for point in polygon query")
+	}
+
+	for _, offset := range []int{1, 3} {
+
+		params := fmt.Sprintf("layer=whosonfirst&zoom=%d", 13+offset)
+		ids := pip(params)
+
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("Unexpected results for %s, %v, expected %v", params, ids, expected)
+		}
+	}
+}
+
+func TestPointInPolygonWithRTree(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&enable_cache=true&layer=whosonfirst&tile-database-uri=rtree://")
+
+	ctx := context.Background()
+
+	lat := 37.759415
+	lon := -122.414647
+
+	pt := orb.Point([2]float64{lon, lat})
+
+	i, err := filter.NewSPRInputs()
 
 	if err != nil {
-		t.Fatalf("Failed to derive absolute path for %s, %v", rel_path, err)
+		t.Fatalf("Failed to create SPR inputs, %v", err)
 	}
 
-	root := filepath.Dir(abs_path)
-	fname := filepath.Base(abs_path)
+	i.IsCurrent = []int64{1}
+
+	f, err := filter.NewSPRFilterFromInputs(i)
+
+	if err != nil {
+		t.Fatalf("Failed to create SPR filter from inputs, %v", err)
+	}
+
+	rsp, err := db.PointInPolygon(ctx, &pt, f)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	results := rsp.Results()
+	count := len(results)
+
+	expected := 9
+
+	if count != expected {
+		t.Fatalf("Unexpected count (%d), expected %d", count, expected)
+	}
+}
 
-	fname = strings.Replace(fname, ".pmtiles", "", 1)
+func TestPointInPolygonConcurrent(t *testing.T) {
 
-	db_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&database=%s&zoom=13&enable_cache=true&layer=whosonfirst", root, fname)
+	db_uri := testDatabaseURI(t, "zoom=13&layer=whosonfirst")
 
 	ctx := context.Background()
 
+	points := []orb.Point{
+		orb.Point{-122.414647, 37.759415},
+		orb.Point{-122.489208, 37.785856},
+		orb.Point{-122.384292, 37.621131},
+	}
+
+	// Derive the expected results using a separate database so that the concurrent
+	// queries below all start with an empty cache of tile databases.
+
+	expected := make([]int, len(points))
+
+	seq_db, err := database.NewSpatialDatabase(ctx, db_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database for %s, %v", db_uri, err)
+	}
+
+	for idx, pt := range points {
+
+		rsp, err := seq_db.PointInPolygon(ctx, &pt)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		expected[idx] = len(rsp.Results())
+	}
+
+	seq_db.Disconnect(ctx)
+
 	db, err := database.NewSpatialDatabase(ctx, db_uri)
 
 	if err != nil {
@@ -142,6 +388,153 @@ func TestIntersects(t *testing.T) {
 
 	defer db.Disconnect(ctx)
 
+	wg := new(sync.WaitGroup)
+	err_ch := make(chan error, len(points)*16)
+
+	for i := 0; i < 16; i++ {
+
+		for idx, pt := range points {
+
+			wg.Add(1)
+
+			go func(idx int, pt orb.Point) {
+
+				defer wg.Done()
+
+				rsp, err := db.PointInPolygon(ctx, &pt)
+
+				if err != nil {
+					err_ch <- fmt.Errorf("Failed to perform point in polygon query, %w", err)
+					return
+				}
+
+				count := len(rsp.Results())
+
+				if count != expected[idx] {
+					err_ch <- fmt.Errorf("Unexpected count (%d) for %v, expected %d", count, pt, expected[idx])
+				}
+
+			}(idx, pt)
+		}
+	}
+
+	wg.Wait()
+	close(err_ch)
+
+	for err := range err_ch {
+		t.Fatal(err)
+	}
+}
+
+func TestPointInPolygonWithLimits(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&layer=whosonfirst&database-ttl=0&max-tile-databases=1")
+
+	ctx := context.Background()
+
+	points := []orb.Point{
+		orb.Point{-122.414647, 37.759415},
+		orb.Point{-122.489208, 37.785856},
+		orb.Point{-122.414647, 37.759415},
+	}
+
+	for _, pt := range points {
+
+		_, err := db.PointInPolygon(ctx, &pt)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		count := db.spatial_databases_lru.Len()
+
+		if count > 1 {
+			t.Fatalf("Unexpected number of tile databases (%d), expected no more than 1", count)
+		}
+	}
+}
+
+func TestPointInPolygonWithTileCache(t *testing.T) {
+
+	tile_cache_dir := t.TempDir()
+
+	params := fmt.Sprintf("zoom=13&layer=whosonfirst&tile-cache-dir=%s", tile_cache_dir)
+
+	ctx := context.Background()
+
+	pt := orb.Point{-122.414647, 37.759415}
+
+	pip := func() ([]string, int64) {
+
+		db := newTestDatabase(t, params)
+
+		rsp, err := db.PointInPolygon(ctx, &pt)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		ids := make([]string, 0)
+
+		for _, r := range rsp.Results() {
+			ids = append(ids, r.Id())
+		}
+
+		slices.Sort(ids)
+		return ids, db.tile_cache.Size()
+	}
+
+	expected, expected_size := pip()
+
+	if len(expected) == 0 {
+		t.Fatalf("Expected results for point in polygon query")
+	}
+
+	if expected_size == 0 {
+		t.Fatalf("Expected tile database to be added to tile cache")
+	}
+
+	// A new database instance (as after a restart) should reopen the tile database from
+	// the tile cache rather than building it again
+
+	ids, size := pip()
+
+	if !slices.Equal(ids, expected) {
+		t.Fatalf("Unexpected results %v, expected %v", ids, expected)
+	}
+
+	if size != expected_size {
+		t.Fatalf("Unexpected tile cache size %d, expected %d", size, expected_size)
+	}
+
+	invalid_uri := testDatabaseURI(t, fmt.Sprintf("tile-database-uri=rtree://&tile-cache-dir=%s", tile_cache_dir))
+
+	_, err := database.NewSpatialDatabase(ctx, invalid_uri)
+
+	if err == nil {
+		t.Fatalf("Expected %s to fail", invalid_uri)
+	}
+}
+
+func TestInvalidTileDatabaseURI(t *testing.T) {
+
+	ctx := context.Background()
+
+	db_uri := "pmtiles://?tiles=file:///tmp&database=sf&tile-database-uri=bogus://"
+
+	_, err := database.NewSpatialDatabase(ctx, db_uri)
+
+	if err == nil {
+		t.Fatalf("Expected %s to fail", db_uri)
+	}
+}
+
+func TestIntersects(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&enable_cache=true&layer=whosonfirst")
+
+	ctx := context.Background()
+
 	// 1108830809
 	feature_id := int64(85847559)
 	feature_path := fmt.Sprintf("fixtures/%d.geojson", feature_id)
@@ -206,3 +599,387 @@ func TestIntersects(t *testing.T) {
 	*/
 
 }
+
+// sharedMemoryDatabases mimics the behaviour of SQLite's "file:{name}?mode=memory&cache=shared" databases
+// where every connection to the same name, in the same process, reads and writes the same database.
+var sharedMemoryDatabases = new(sync.Map)
+
+func init() {
+	ctx := context.Background()
+	database.RegisterSpatialDatabase(ctx, "sharedmemory", newSharedMemorySpatialDatabase)
+}
+
+func newSharedMemorySpatialDatabase(ctx context.Context, uri string) (database.SpatialDatabase, error) {
+	v, _ := sharedMemoryDatabases.LoadOrStore(uri, newTileSpatialDatabase())
+	return v.(database.SpatialDatabase), nil
+}
+
+func TestPointInPolygonIsolation(t *testing.T) {
+
+	tile_db_uri := url.QueryEscape("sharedmemory://?dsn={dbname}")
+	db_uri := testDatabaseURI(t, fmt.Sprintf("zoom=13&layer=whosonfirst&tile-database-uri=%s", tile_db_uri))
+
+	ctx := context.Background()
+
+	lat := 37.759415
+	lon := -122.414647
+
+	pt := orb.Point([2]float64{lon, lat})
+
+	// If both databases were to use the same per-tile database then the second one
+	// would index the features in the tile again and return duplicate results.
+
+	counts := make([]int, 2)
+
+	for i := range counts {
+
+		db, err := database.NewSpatialDatabase(ctx, db_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", db_uri, err)
+		}
+
+		defer db.Disconnect(ctx)
+
+		spr, err := db.PointInPolygon(ctx, &pt)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		counts[i] = len(spr.Results())
+	}
+
+	if counts[0] == 0 {
+		t.Fatalf("Expected results")
+	}
+
+	if counts[0] != counts[1] {
+		t.Fatalf("Unexpected count (%d) for second database, expected %d", counts[1], counts[0])
+	}
+}
+
+func TestIntersectsWithPlacetypeFilter(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&layer=whosonfirst")
+
+	ctx := context.Background()
+
+	f := readFixtureFeature(t, "fixtures/85847559.geojson")
+
+	rsp, err := db.Intersects(ctx, f.Geometry)
+
+	if err != nil {
+		t.Fatalf("Failed to perform intersects query, %v", err)
+	}
+
+	if len(rsp.Results()) == 0 {
+		t.Fatalf("Expected intersecting features")
+	}
+
+	// There are no continents in San Francisco
+
+	i, err := filter.NewSPRInputs()
+
+	if err != nil {
+		t.Fatalf("Failed to create SPR inputs, %v", err)
+	}
+
+	i.Placetypes = []string{"continent"}
+
+	fl, err := filter.NewSPRFilterFromInputs(i)
+
+	if err != nil {
+		t.Fatalf("Failed to create SPR filter from inputs, %v", err)
+	}
+
+	rsp, err = db.Intersects(ctx, f.Geometry, fl)
+
+	if err != nil {
+		t.Fatalf("Failed to perform intersects query, %v", err)
+	}
+
+	count := len(rsp.Results())
+
+	if count != 0 {
+		t.Fatalf("Unexpected count (%d), expected 0", count)
+	}
+}
+
+func TestIntersectsWithIteratorEarlyTermination(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&layer=whosonfirst")
+
+	ctx := context.Background()
+
+	f := readFixtureFeature(t, "fixtures/85847559.geojson")
+
+	count := 0
+
+	for _, err := range db.IntersectsWithIterator(ctx, f.Geometry) {
+
+		if err != nil {
+			t.Fatalf("Failed to perform intersects query, %v", err)
+		}
+
+		count += 1
+		break
+	}
+
+	if count != 1 {
+		t.Fatalf("Unexpected count (%d), expected 1", count)
+	}
+
+	cancel_ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	errors := 0
+
+	for _, err := range db.IntersectsWithIterator(cancel_ctx, f.Geometry) {
+
+		if err == nil {
+			t.Fatalf("Expected an error for cancelled context")
+		}
+
+		errors += 1
+	}
+
+	if errors != 1 {
+		t.Fatalf("Unexpected error count (%d), expected 1", errors)
+	}
+}
+
+func TestIntersectsWithTileFetchConcurrency(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&layer=whosonfirst&tile-fetch-concurrency=2")
+
+	ctx := context.Background()
+
+	f := readFixtureFeature(t, "fixtures/85847559.geojson")
+
+	for i := 0; i < 10; i++ {
+
+		for _, err := range db.IntersectsWithIterator(ctx, f.Geometry) {
+
+			if err != nil {
+				t.Fatalf("Failed to perform intersects query, %v", err)
+			}
+
+			break
+		}
+
+		// Outstanding reads are completed (or cancelled) before the iterator returns
+
+		fetches := db.tile_fetches.Load()
+
+		if fetches != 0 {
+			t.Fatalf("Unexpected number of tiles (%d) still being read after iteration ended", fetches)
+		}
+	}
+
+	peak := db.tile_fetches_peak.Load()
+
+	if peak == 0 || peak > 2 {
+		t.Fatalf("Unexpected peak number of tiles (%d) read at the same time, expected between 1 and 2", peak)
+	}
+
+	_, err := database.NewSpatialDatabase(ctx, testDatabaseURI(t, "zoom=13&layer=whosonfirst&tile-fetch-concurrency=0"))
+
+	if err == nil {
+		t.Fatalf("Expected ?tile-fetch-concurrency=0 to fail")
+	}
+}
+
+func TestIntersectsWithMaxTiles(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&layer=whosonfirst&max-intersects-tiles=1")
+
+	ctx := context.Background()
+
+	f := readFixtureFeature(t, "fixtures/85847559.geojson")
+
+	count, err := db.IntersectsTileCount(ctx, f.Geometry)
+
+	if err != nil {
+		t.Fatalf("Failed to count tiles, %v", err)
+	}
+
+	if count < 2 {
+		t.Fatalf("Unexpected tile count (%d), expected at least 2", count)
+	}
+
+	_, err = db.Intersects(ctx, f.Geometry)
+
+	var too_many *TooManyTilesError
+
+	if !errors.As(err, &too_many) {
+		t.Fatalf("Expected TooManyTilesError, got %v", err)
+	}
+}
+
+func TestIntersectsWithMinZoom(t *testing.T) {
+
+	ctx := context.Background()
+
+	// A geometry slightly larger than a tile at zoom 13 so that the tile is wholly contained
+	// by it
+
+	b := maptile.At(orb.Point{-122.414647, 37.759415}, 13).Bound()
+
+	pad_x := (b.Max.X() - b.Min.X()) / 100
+	pad_y := (b.Max.Y() - b.Min.Y()) / 100
+
+	geom := orb.Bound{
+		Min: orb.Point{b.Min.X() - pad_x, b.Min.Y() - pad_y},
+		Max: orb.Point{b.Max.X() + pad_x, b.Max.Y() + pad_y},
+	}.ToPolygon()
+
+	// Returns the number of tiles read and the number of results
+
+	fetches := func(db *PMTilesSpatialDatabase) (int64, int) {
+
+		count, err := db.IntersectsTileCount(ctx, geom)
+
+		if err != nil {
+			t.Fatalf("Failed to count tiles, %v", err)
+		}
+
+		rsp, err := db.Intersects(ctx, geom)
+
+		if err != nil {
+			t.Fatalf("Failed to perform intersects query, %v", err)
+		}
+
+		if len(rsp.Results()) == 0 {
+			t.Fatalf("Expected intersects query to return results")
+		}
+
+		total := db.tile_fetches_total.Load()
+
+		if total != count {
+			t.Fatalf("Unexpected number of tiles read (%d), expected %d", total, count)
+		}
+
+		return total, len(rsp.Results())
+	}
+
+	// Tiles at zoom 15 are overzoomed from the tiles at zoom 13 so the results should be
+	// the same
+
+	leaves, leaves_results := fetches(newTestDatabase(t, "zoom=15&layer=whosonfirst"))
+	tiles, tiles_results := fetches(newTestDatabase(t, "zoom=15&layer=whosonfirst&intersects-min-zoom=13"))
+
+	if tiles >= leaves {
+		t.Fatalf("Expected fewer tiles (%d) to be read than at zoom 15 (%d)", tiles, leaves)
+	}
+
+	if tiles_results != leaves_results {
+		t.Fatalf("Unexpected number of results (%d), expected %d", tiles_results, leaves_results)
+	}
+
+	_, err := NewPMTilesSpatialDatabase(ctx, testDatabaseURI(t, "zoom=15&layer=whosonfirst&intersects-min-zoom=16"))
+
+	if err == nil {
+		t.Fatalf("Expected ?intersects-min-zoom= greater than ?zoom= to fail")
+	}
+}
+
+func TestTileDataCancelled(t *testing.T) {
+
+	db := newTestDatabase(t, "zoom=13&layer=whosonfirst")
+
+	r := db.router.Load()
+	tile := maptile.At(orb.Point{-122.414647, 37.759415}, 13)
+
+	a := r.archiveForTile(tile)
+
+	if a == nil {
+		t.Fatalf("Expected tile to be covered by database")
+	}
+
+	ctx := context.Background()
+
+	status_code, _, err := db.tileData(ctx, a, tile)
+
+	if err != nil {
+		t.Fatalf("Failed to read tile, %v", err)
+	}
+
+	if status_code != 200 {
+		t.Fatalf("Unexpected status code %d, expected 200", status_code)
+	}
+
+	// Cancelling the context should be reported rather than treated as a missing tile
+
+	cancel_ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, _, err = db.tileData(cancel_ctx, a, tile)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+
+	_, err = db.featuresForTile(cancel_ctx, r, tile)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestFeaturesForLayers(t *testing.T) {
+
+	tile := maptile.At(orb.Point{-122.414647, 37.759415}, 13)
+	pt := tile.Bound().Center()
+
+	newFeature := func(id int64, source string) *geojson.Feature {
+		f := geojson.NewFeature(pt)
+		f.Properties["wof:id"] = id
+		f.Properties["source"] = source
+		return f
+	}
+
+	wof := geojson.NewFeatureCollection()
+	wof.Append(newFeature(1, "whosonfirst"))
+	wof.Append(newFeature(2, "whosonfirst"))
+
+	sfom := geojson.NewFeatureCollection()
+	sfom.Append(newFeature(2, "sfomuseum"))
+	sfom.Append(newFeature(3, "sfomuseum"))
+
+	layers := mvt.NewLayers(map[string]*geojson.FeatureCollection{
+		"whosonfirst": wof,
+		"sfomuseum":   sfom,
+	})
+
+	layers.ProjectToTile(tile)
+
+	// The "custom" layer is absent from the tile and "sfomuseum" takes precedence over "whosonfirst"
+
+	features := featuresForLayers(tile, layers, []string{"custom", "sfomuseum", "whosonfirst"})
+
+	if len(features) != 3 {
+		t.Fatalf("Unexpected number of features (%d), expected 3", len(features))
+	}
+
+	sources := make(map[string]string)
+
+	for _, f := range features {
+
+		id, err := tileFeatureId(f)
+
+		if err != nil {
+			t.Fatalf("Failed to derive feature ID, %v", err)
+		}
+
+		sources[id] = f.Properties.MustString("source")
+	}
+
+	if sources["2"] != "sfomuseum" {
+		t.Fatalf("Unexpected source for feature 2 (%s), expected sfomuseum", sources["2"])
+	}
+
+	if sources["1"] != "whosonfirst" || sources["3"] != "sfomuseum" {
+		t.Fatalf("Unexpected sources %v", sources)
+	}
+}
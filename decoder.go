@@ -0,0 +1,272 @@
+package pmtiles
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// PropertyDecoderFunc is a function used to decode the value of a property which was stringified in the process
+// of encoding it as MVT. It is passed the name of the property and its (stringified) value and returns the decoded
+// value which will replace the original value in the feature's properties.
+type PropertyDecoderFunc func(ctx context.Context, key string, value string) (any, error)
+
+var property_decoders = make(map[string]PropertyDecoderFunc)
+
+var property_decoders_mutex = new(sync.RWMutex)
+
+func init() {
+
+	ctx := context.Background()
+
+	builtin := map[string]PropertyDecoderFunc{
+		"wof:superseded_by": NewJSONPropertyDecoder[[]int64](),
+		"wof:supersedes":    NewJSONPropertyDecoder[[]int64](),
+		"wof:belongsto":     NewJSONPropertyDecoder[[]int64](),
+		"wof:hierarchy":     NewJSONPropertyDecoder[[]map[string]int64](),
+		"wof:concordances":  NewJSONPropertyDecoder[map[string]any](),
+		"wof:lang_x_spoken": NewJSONPropertyDecoder[[]string](),
+		// Not all "name:" properties are lists, for example "name:eng_x_preferred_disambiguation"
+		"name:*": newOptionalJSONPropertyDecoder(NewJSONPropertyDecoder[[]string]()),
+	}
+
+	for pattern, decoder := range builtin {
+
+		err := RegisterPropertyDecoder(ctx, pattern, decoder)
+
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterPropertyDecoder registers 'decoder' as the function used to decode the values of properties matching
+// 'pattern'. 'pattern' is either the name of a property or a glob, as defined by `path.Match`, for example "name:*".
+// A property whose name matches a registered name exactly is decoded using that decoder; otherwise the most specific
+// (longest) matching glob is used. It is an error to register the same pattern twice.
+func RegisterPropertyDecoder(ctx context.Context, pattern string, decoder PropertyDecoderFunc) error {
+
+	if pattern == "" {
+		return fmt.Errorf("Missing pattern")
+	}
+
+	if decoder == nil {
+		return fmt.Errorf("Missing decoder for %s", pattern)
+	}
+
+	_, err := path.Match(pattern, "")
+
+	if err != nil {
+		return fmt.Errorf("Invalid pattern %s, %w", pattern, err)
+	}
+
+	property_decoders_mutex.Lock()
+	defer property_decoders_mutex.Unlock()
+
+	_, exists := property_decoders[pattern]
+
+	if exists {
+		return fmt.Errorf("Property decoder for %s has already been registered", pattern)
+	}
+
+	property_decoders[pattern] = decoder
+	return nil
+}
+
+// PropertyDecoderPatterns returns the sorted list of patterns for which property decoders have been registered.
+func PropertyDecoderPatterns() []string {
+
+	property_decoders_mutex.RLock()
+	defer property_decoders_mutex.RUnlock()
+
+	patterns := make([]string, 0, len(property_decoders))
+
+	for pattern, _ := range property_decoders {
+		patterns = append(patterns, pattern)
+	}
+
+	slices.Sort(patterns)
+	return patterns
+}
+
+// NewJSONPropertyDecoder returns a `PropertyDecoderFunc` which unmarshals stringified JSON values in to an instance of 'T'.
+func NewJSONPropertyDecoder[T any]() PropertyDecoderFunc {
+
+	fn := func(ctx context.Context, key string, value string) (any, error) {
+
+		var v T
+
+		err := json.Unmarshal([]byte(value), &v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return v, nil
+	}
+
+	return fn
+}
+
+// newOptionalJSONPropertyDecoder returns a `PropertyDecoderFunc` which invokes 'decoder' for values that are
+// stringified JSON arrays or objects and returns all other values unchanged.
+func newOptionalJSONPropertyDecoder(decoder PropertyDecoderFunc) PropertyDecoderFunc {
+
+	fn := func(ctx context.Context, key string, value string) (any, error) {
+
+		if !isStringifiedJSON(value) {
+			return value, nil
+		}
+
+		return decoder(ctx, key, value)
+	}
+
+	return fn
+}
+
+// propertyDecoder returns the `PropertyDecoderFunc` registered for 'key' and a boolean value indicating whether one was found.
+func propertyDecoder(key string) (PropertyDecoderFunc, bool) {
+
+	property_decoders_mutex.RLock()
+	defer property_decoders_mutex.RUnlock()
+
+	decoder, exists := property_decoders[key]
+
+	if exists {
+		return decoder, true
+	}
+
+	var match string
+
+	for pattern, fn := range property_decoders {
+
+		ok, _ := path.Match(pattern, key)
+
+		if !ok {
+			continue
+		}
+
+		if match == "" || len(pattern) > len(match) || (len(pattern) == len(match) && pattern < match) {
+			match = pattern
+			decoder = fn
+		}
+	}
+
+	return decoder, match != ""
+}
+
+// isStringifiedJSON reports whether 'value' looks like, and is, a JSON-encoded array or object.
+func isStringifiedJSON(value string) bool {
+
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return false
+	}
+
+	switch value[0] {
+	case '[', '{':
+		return json.Valid([]byte(value))
+	default:
+		return false
+	}
+}
+
+// decodeProperties expands the values of properties in 'body' that were stringified in the process of encoding them
+// as MVT using the property decoders that have been registered. If 'auto_detect' is true then the values of
+// properties without a registered decoder that are stringified JSON arrays or objects are expanded as well.
+// https://docs.mapbox.com/data/tilesets/guides/vector-tiles-standards/#how-to-encode-attributes-that-arent-strings-or-numbers
+func decodeProperties(ctx context.Context, body []byte, auto_detect bool) ([]byte, error) {
+
+	props := gjson.GetBytes(body, "properties")
+
+	if !props.Exists() {
+		return body, nil
+	}
+
+	var err error
+
+	props.ForEach(func(k gjson.Result, v gjson.Result) bool {
+
+		if v.Type != gjson.String {
+			return true
+		}
+
+		key := k.String()
+		prop_path := fmt.Sprintf("properties.%s", escapePropertyPath(key))
+
+		decoder, exists := propertyDecoder(key)
+
+		if exists {
+
+			var value any
+			value, err = decoder(ctx, key, v.String())
+
+			if err != nil {
+				err = fmt.Errorf("Failed to decode %s value (%s), %w", key, v.String(), err)
+				return false
+			}
+
+			body, err = sjson.SetBytes(body, prop_path, value)
+
+			if err != nil {
+				err = fmt.Errorf("Failed to set %s, %w", prop_path, err)
+				return false
+			}
+
+			return true
+		}
+
+		if auto_detect && isStringifiedJSON(v.String()) {
+
+			var buf bytes.Buffer
+			err = json.Compact(&buf, []byte(v.String()))
+
+			if err != nil {
+				err = fmt.Errorf("Failed to compact %s value (%s), %w", key, v.String(), err)
+				return false
+			}
+
+			body, err = sjson.SetRawBytes(body, prop_path, buf.Bytes())
+
+			if err != nil {
+				err = fmt.Errorf("Failed to set %s, %w", prop_path, err)
+				return false
+			}
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// escapePropertyPath escapes the characters in 'key' which have special meaning in a gjson/sjson path.
+func escapePropertyPath(key string) string {
+
+	var b strings.Builder
+
+	for _, r := range key {
+
+		switch r {
+		case '.', '*', '?', '|', '#', '@', '\\':
+			b.WriteRune('\\')
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package pmtiles
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestDecodeProperties(t *testing.T) {
+
+	ctx := context.Background()
+
+	err := RegisterPropertyDecoder(ctx, "sfomuseum:*", func(ctx context.Context, key string, value string) (any, error) {
+		return strings.ToUpper(value), nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to register property decoder, %v", err)
+	}
+
+	err = RegisterPropertyDecoder(ctx, "sfomuseum:*", NewJSONPropertyDecoder[[]string]())
+
+	if err == nil {
+		t.Fatalf("Expected error registering the same pattern twice")
+	}
+
+	err = RegisterPropertyDecoder(ctx, "[", NewJSONPropertyDecoder[[]string]())
+
+	if err == nil {
+		t.Fatalf("Expected error registering an invalid pattern")
+	}
+
+	body := []byte(`{"type":"Feature","properties":{"wof:id":102087579,"wof:belongsto":"[102191575,85633793]","wof:hierarchy":"[{\"country_id\":85633793,\"region_id\":85688637}]","wof:concordances":"{\"gn:id\":5391997,\"wd:id\":\"Q62\"}","wof:lang_x_spoken":"[\"eng\",\"spa\"]","name:eng_x_preferred":"[\"San Francisco\"]","name:eng_x_preferred_disambiguation":"SF","sfomuseum:placetype":"museum","misc:tags":"[\"a\",\"b\"]","misc:label":"[not json"},"geometry":{"type":"Point","coordinates":[0,0]}}`)
+
+	decoded, err := decodeProperties(ctx, body, false)
+
+	if err != nil {
+		t.Fatalf("Failed to decode properties, %v", err)
+	}
+
+	tests := map[string]string{
+		"properties.wof:belongsto.1":                     "85633793",
+		"properties.wof:hierarchy.0.region_id":           "85688637",
+		"properties.wof:concordances.gn\\:id":            "5391997",
+		"properties.wof:concordances.wd\\:id":            "Q62",
+		"properties.wof:lang_x_spoken.1":                 "spa",
+		"properties.name:eng_x_preferred.0":              "San Francisco",
+		"properties.name:eng_x_preferred_disambiguation": "SF",
+		"properties.sfomuseum:placetype":                 "MUSEUM",
+		"properties.misc:tags":                           `["a","b"]`,
+		"properties.misc:label":                          "[not json",
+	}
+
+	for path, expected := range tests {
+
+		v := gjson.GetBytes(decoded, path)
+
+		if v.String() != expected {
+			t.Fatalf("Unexpected value for %s '%s', expected '%s'", path, v.String(), expected)
+		}
+	}
+
+	// Stringified JSON values without a decoder are only expanded when auto-detection is enabled
+
+	decoded, err = decodeProperties(ctx, body, true)
+
+	if err != nil {
+		t.Fatalf("Failed to decode properties, %v", err)
+	}
+
+	if !gjson.GetBytes(decoded, "properties.misc:tags").IsArray() {
+		t.Fatalf("Expected misc:tags to be decoded as an array")
+	}
+
+	if gjson.GetBytes(decoded, "properties.misc:label").String() != "[not json" {
+		t.Fatalf("Expected misc:label to be left unchanged")
+	}
+
+	// Registered decoders still fail loudly for invalid values
+
+	_, err = decodeProperties(ctx, []byte(`{"properties":{"wof:belongsto":"[1,"}}`), true)
+
+	if err == nil {
+		t.Fatalf("Expected error decoding invalid wof:belongsto value")
+	}
+}
@@ -0,0 +1,82 @@
+package pmtiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// FeatureCacheHandler returns an `http.Handler` for inspecting, and modifying, the feature cache of 'db' while it
+// is in use. It handles the following requests:
+//
+//	GET /stats                  Emit the JSON-encoded `cache.CacheStats` for the feature cache.
+//	POST /delete?id={ID}&id=... Remove the features with the IDs listed in one or more ?id= parameters.
+//	POST /purge                 Remove all the features from the feature cache.
+//
+// An error is returned if the feature cache is not enabled.
+func FeatureCacheHandler(db *PMTilesSpatialDatabase) (http.Handler, error) {
+
+	m, enabled := db.FeatureCache()
+
+	if !enabled {
+		return nil, fmt.Errorf("Feature cache is not enabled")
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /stats", func(rsp http.ResponseWriter, req *http.Request) {
+
+		stats, err := m.Stats(req.Context())
+
+		if err != nil {
+			http.Error(rsp, fmt.Sprintf("Failed to derive stats, %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(rsp)
+		err = enc.Encode(stats)
+
+		if err != nil {
+			http.Error(rsp, fmt.Sprintf("Failed to encode stats, %v", err), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	mux.HandleFunc("POST /delete", func(rsp http.ResponseWriter, req *http.Request) {
+
+		ids := req.URL.Query()["id"]
+
+		if len(ids) == 0 {
+			http.Error(rsp, "Missing ?id= parameter", http.StatusBadRequest)
+			return
+		}
+
+		for _, id := range ids {
+
+			err := m.DeleteFeatureCache(req.Context(), id)
+
+			if err != nil {
+				http.Error(rsp, fmt.Sprintf("Failed to delete %s, %v", id, err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		rsp.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /purge", func(rsp http.ResponseWriter, req *http.Request) {
+
+		err := m.Purge(req.Context())
+
+		if err != nil {
+			http.Error(rsp, fmt.Sprintf("Failed to purge feature cache, %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		rsp.WriteHeader(http.StatusNoContent)
+	})
+
+	return mux, nil
+}
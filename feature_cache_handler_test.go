@@ -0,0 +1,115 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-spatial-pmtiles/cache"
+)
+
+func TestFeatureCacheHandler(t *testing.T) {
+
+	ctx := context.Background()
+
+	_, err := FeatureCacheHandler(&PMTilesSpatialDatabase{})
+
+	if err == nil {
+		t.Fatalf("Expected handler for database without a feature cache to fail")
+	}
+
+	m, err := cache.NewCacheManager(ctx, "memory://")
+
+	if err != nil {
+		t.Fatalf("Failed to create cache manager, %v", err)
+	}
+
+	defer m.Close()
+
+	for _, id := range []int{101, 102, 103} {
+
+		_, err := m.CacheFeature(ctx, []byte(fmt.Sprintf(`{"type":"Feature","properties":{"wof:id":%d},"geometry":null}`, id)))
+
+		if err != nil {
+			t.Fatalf("Failed to cache feature %d, %v", id, err)
+		}
+	}
+
+	db := &PMTilesSpatialDatabase{
+		enable_feature_cache: true,
+		cache_manager:        m,
+	}
+
+	handler, err := FeatureCacheHandler(db)
+
+	if err != nil {
+		t.Fatalf("Failed to create feature cache handler, %v", err)
+	}
+
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	entries := func() int64 {
+
+		rsp, err := http.Get(s.URL + "/stats")
+
+		if err != nil {
+			t.Fatalf("Failed to request stats, %v", err)
+		}
+
+		defer rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			t.Fatalf("Unexpected status code for stats, %d", rsp.StatusCode)
+		}
+
+		var stats *cache.CacheStats
+
+		err = json.NewDecoder(rsp.Body).Decode(&stats)
+
+		if err != nil {
+			t.Fatalf("Failed to decode stats, %v", err)
+		}
+
+		return stats.Entries
+	}
+
+	post := func(path string) int {
+
+		rsp, err := http.Post(s.URL+path, "", nil)
+
+		if err != nil {
+			t.Fatalf("Failed to post %s, %v", path, err)
+		}
+
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+
+	if entries() != 3 {
+		t.Fatalf("Expected 3 entries")
+	}
+
+	if post("/delete") != http.StatusBadRequest {
+		t.Fatalf("Expected delete without IDs to fail")
+	}
+
+	if post("/delete?id=101&id=102") != http.StatusNoContent {
+		t.Fatalf("Failed to delete features")
+	}
+
+	if entries() != 1 {
+		t.Fatalf("Expected 1 entry after delete")
+	}
+
+	if post("/purge") != http.StatusNoContent {
+		t.Fatalf("Failed to purge features")
+	}
+
+	if entries() != 0 {
+		t.Fatalf("Expected 0 entries after purge")
+	}
+}
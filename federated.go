@@ -0,0 +1,419 @@
+package pmtiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-reader/v2"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// FEDERATED_SCHEME is the URI scheme used to signal that queries should be performed against multiple PMTiles databases.
+const FEDERATED_SCHEME string = "pmtiles-federated"
+
+func init() {
+	ctx := context.Background()
+	database.RegisterSpatialDatabase(ctx, FEDERATED_SCHEME, NewFederatedSpatialDatabase)
+	reader.RegisterReader(ctx, FEDERATED_SCHEME, NewFederatedSpatialDatabaseReader)
+}
+
+// FederatedSpatialDatabase implements the `whosonfirst/go-whosonfirst-spatial/database.SpatialDatabase` interface
+// for multiple `PMTilesSpatialDatabase` instances, for example one for global administrative areas and another for
+// venues in a single city. Queries are performed against all the databases at the same time and the results are
+// merged. If the same feature is returned by more than one database the result from the database with the highest
+// precedence is used.
+type FederatedSpatialDatabase struct {
+	database.SpatialDatabase
+	// members is the list of databases to query, ordered by precedence (highest first).
+	members []*federatedMember
+}
+
+// federatedMember is a single database in a `FederatedSpatialDatabase`.
+type federatedMember struct {
+	name string
+	db   database.SpatialDatabase
+}
+
+func NewFederatedSpatialDatabaseReader(ctx context.Context, uri string) (reader.Reader, error) {
+	return NewFederatedSpatialDatabase(ctx, uri)
+}
+
+// NewFederatedSpatialDatabase returns a new `FederatedSpatialDatabase` instance configured by 'uri' which is
+// expected to take the form of:
+//
+//	pmtiles-federated://?database-uri={PMTILES_URI}&database-uri={PMTILES_URI}&precedence={NAMES}
+//
+// Where each (URL-escaped) {PMTILES_URI} is a valid `pmtiles://` URI, with its own tiles, database, zoom, layer
+// and other parameters. Each database is named by the "name" parameter in its URI or, if absent, the value of its
+// "database" parameter and names must be unique. By default databases take precedence in the order they are listed.
+// The optional "precedence" parameter is a comma-separated list of names used to define a different order; any
+// databases it omits follow, in the order they are listed.
+func NewFederatedSpatialDatabase(ctx context.Context, uri string) (database.SpatialDatabase, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	if u.Scheme != FEDERATED_SCHEME {
+		return nil, fmt.Errorf("Invalid scheme, %s", u.Scheme)
+	}
+
+	q := u.Query()
+
+	member_uris := q["database-uri"]
+
+	if len(member_uris) == 0 {
+		return nil, fmt.Errorf("Missing ?database-uri= parameter")
+	}
+
+	members := make([]*federatedMember, 0, len(member_uris))
+
+	close_members := func() {
+
+		for _, m := range members {
+			m.db.Disconnect(ctx)
+		}
+	}
+
+	for _, member_uri := range member_uris {
+
+		member_u, err := url.Parse(member_uri)
+
+		if err != nil {
+			close_members()
+			return nil, fmt.Errorf("Failed to parse ?database-uri= parameter, %w", err)
+		}
+
+		if member_u.Scheme != "pmtiles" {
+			close_members()
+			return nil, fmt.Errorf("Invalid ?database-uri= parameter, %s:// is not a pmtiles:// URI", member_u.Scheme)
+		}
+
+		member_q := member_u.Query()
+
+		name := member_q.Get("name")
+
+		if name == "" {
+			name = member_q.Get("database")
+		}
+
+		for _, m := range members {
+
+			if m.name == name {
+				close_members()
+				return nil, fmt.Errorf("Invalid ?database-uri= parameter, more than one database is named %s", name)
+			}
+		}
+
+		member_db, err := NewPMTilesSpatialDatabase(ctx, member_uri)
+
+		if err != nil {
+			close_members()
+			return nil, fmt.Errorf("Failed to create spatial database for %s, %w", name, err)
+		}
+
+		m := &federatedMember{
+			name: name,
+			db:   member_db,
+		}
+
+		members = append(members, m)
+	}
+
+	if q.Has("precedence") {
+
+		ordered := make([]*federatedMember, 0, len(members))
+
+		for _, name := range strings.Split(q.Get("precedence"), ",") {
+
+			name = strings.TrimSpace(name)
+
+			idx := slices.IndexFunc(members, func(m *federatedMember) bool {
+				return m.name == name
+			})
+
+			if idx == -1 {
+				close_members()
+				return nil, fmt.Errorf("Invalid ?precedence= parameter, %s is not the name of a database", name)
+			}
+
+			if slices.Contains(ordered, members[idx]) {
+				close_members()
+				return nil, fmt.Errorf("Invalid ?precedence= parameter, %s is listed more than once", name)
+			}
+
+			ordered = append(ordered, members[idx])
+		}
+
+		for _, m := range members {
+
+			if !slices.Contains(ordered, m) {
+				ordered = append(ordered, m)
+			}
+		}
+
+		members = ordered
+	}
+
+	db := &FederatedSpatialDatabase{
+		members: members,
+	}
+
+	return db, nil
+}
+
+func (db *FederatedSpatialDatabase) IndexFeature(context.Context, []byte) error {
+	return spatial.ErrNotImplemented
+}
+
+func (db *FederatedSpatialDatabase) RemoveFeature(context.Context, string) error {
+	return spatial.ErrNotImplemented
+}
+
+func (db *FederatedSpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	results := make([]spr.StandardPlacesResult, 0)
+
+	for r, err := range db.PointInPolygonWithIterator(ctx, coord, filters...) {
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, r)
+	}
+
+	spr_results := &PMTilesResults{
+		Places: results,
+	}
+
+	return spr_results, nil
+}
+
+func (db *FederatedSpatialDatabase) PointInPolygonWithIterator(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) iter.Seq2[spr.StandardPlacesResult, error] {
+
+	return db.fanOut(ctx, func(ctx context.Context, member_db database.SpatialDatabase) iter.Seq2[spr.StandardPlacesResult, error] {
+		return member_db.PointInPolygonWithIterator(ctx, coord, filters...)
+	})
+}
+
+func (db *FederatedSpatialDatabase) Intersects(ctx context.Context, geom orb.Geometry, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	results := make([]spr.StandardPlacesResult, 0)
+
+	for r, err := range db.IntersectsWithIterator(ctx, geom, filters...) {
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, r)
+	}
+
+	spr_results := &PMTilesResults{
+		Places: results,
+	}
+
+	return spr_results, nil
+}
+
+func (db *FederatedSpatialDatabase) IntersectsWithIterator(ctx context.Context, geom orb.Geometry, filters ...spatial.Filter) iter.Seq2[spr.StandardPlacesResult, error] {
+
+	return db.fanOut(ctx, func(ctx context.Context, member_db database.SpatialDatabase) iter.Seq2[spr.StandardPlacesResult, error] {
+		return member_db.IntersectsWithIterator(ctx, geom, filters...)
+	})
+}
+
+// fanOut returns an iterator of the results of invoking 'query' against all the databases in 'db' at the same time.
+// Results are yielded in order of precedence: the results from a database are yielded once it, and all the databases
+// with a higher precedence, have completed. Results for features which have already been yielded (by a database with
+// a higher precedence) are skipped. If the iterator's yield function returns false or an error is encountered then
+// any outstanding queries are cancelled and the iterator does not return until they have completed.
+func (db *FederatedSpatialDatabase) fanOut(ctx context.Context, query func(context.Context, database.SpatialDatabase) iter.Seq2[spr.StandardPlacesResult, error]) iter.Seq2[spr.StandardPlacesResult, error] {
+
+	return func(yield func(spr.StandardPlacesResult, error) bool) {
+
+		type memberResults struct {
+			results []spr.StandardPlacesResult
+			err     error
+			done    chan bool
+		}
+
+		query_ctx, query_cancel := context.WithCancel(ctx)
+
+		wg := new(sync.WaitGroup)
+
+		defer func() {
+			query_cancel()
+			wg.Wait()
+		}()
+
+		all_results := make([]*memberResults, len(db.members))
+
+		for i, m := range db.members {
+
+			mr := &memberResults{
+				results: make([]spr.StandardPlacesResult, 0),
+				done:    make(chan bool),
+			}
+
+			all_results[i] = mr
+
+			wg.Add(1)
+
+			go func(m *federatedMember, mr *memberResults) {
+
+				defer wg.Done()
+				defer close(mr.done)
+
+				for r, err := range query(query_ctx, m.db) {
+
+					if err != nil {
+						mr.err = fmt.Errorf("Failed to query %s database, %w", m.name, err)
+						return
+					}
+
+					mr.results = append(mr.results, r)
+				}
+			}(m, mr)
+		}
+
+		seen := make(map[string]bool)
+
+		for _, mr := range all_results {
+
+			select {
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			case <-mr.done:
+				// pass
+			}
+
+			if mr.err != nil {
+				yield(nil, mr.err)
+				return
+			}
+
+			for _, r := range mr.results {
+
+				key := federatedResultKey(r)
+
+				if seen[key] {
+					continue
+				}
+
+				seen[key] = true
+
+				if !yield(r, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// federatedResultKey returns the key used to determine whether 'r' has already been returned by another database.
+// The path is used, when present, rather than the ID so that alternate geometries are not considered duplicates.
+func federatedResultKey(r spr.StandardPlacesResult) string {
+
+	path := r.Path()
+
+	if path != "" {
+		return path
+	}
+
+	return r.Id()
+}
+
+// Read returns the feature for 'path' from the first database, in order of precedence, which contains it.
+func (db *FederatedSpatialDatabase) Read(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+
+	var last_err error
+
+	for _, m := range db.members {
+
+		r, err := m.db.Read(ctx, path)
+
+		if err == nil {
+			return r, nil
+		}
+
+		last_err = err
+	}
+
+	if last_err == nil || errors.Is(last_err, spatial.ErrNotFound) {
+		return nil, spatial.ErrNotFound
+	}
+
+	return nil, last_err
+}
+
+// Exists reports whether any of the databases contain the feature for 'path'.
+func (db *FederatedSpatialDatabase) Exists(ctx context.Context, path string) (bool, error) {
+
+	for _, m := range db.members {
+
+		exists, err := m.db.Exists(ctx, path)
+
+		if err == nil && exists {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (db *FederatedSpatialDatabase) ReaderURI(ctx context.Context, path string) string {
+	return path
+}
+
+func (db *FederatedSpatialDatabase) Write(ctx context.Context, key string, fh io.ReadSeeker) (int64, error) {
+	return 0, spatial.ErrNotImplemented
+}
+
+func (db *FederatedSpatialDatabase) WriterURI(ctx context.Context, str_uri string) string {
+	return str_uri
+}
+
+func (db *FederatedSpatialDatabase) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (db *FederatedSpatialDatabase) Close(ctx context.Context) error {
+	return nil
+}
+
+func (db *FederatedSpatialDatabase) SetLogger(ctx context.Context, logger *log.Logger) error {
+	return nil
+}
+
+// Disconnect disconnects all the databases in 'db'.
+func (db *FederatedSpatialDatabase) Disconnect(ctx context.Context) error {
+
+	errs := make([]error, 0)
+
+	for _, m := range db.members {
+
+		err := m.db.Disconnect(ctx)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Failed to disconnect %s database, %w", m.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
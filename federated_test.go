@@ -0,0 +1,123 @@
+package pmtiles
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestFederatedSpatialDatabase(t *testing.T) {
+
+	abs_path := testFixturePath(t)
+
+	root := filepath.Dir(abs_path)
+	fname := strings.Replace(filepath.Base(abs_path), ".pmtiles", "", 1)
+
+	ctx := context.Background()
+
+	member_uri := func(name string) string {
+		return fmt.Sprintf("pmtiles://?tiles=file://%s&database=%s&zoom=13&layer=whosonfirst&name=%s", root, fname, name)
+	}
+
+	pt := orb.Point{-122.414647, 37.759415}
+
+	single_db, err := database.NewSpatialDatabase(ctx, member_uri("a"))
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	defer single_db.Disconnect(ctx)
+
+	single_rsp, err := single_db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	// The same database federated twice should yield the same (deduplicated) results
+
+	db_uri := fmt.Sprintf("%s://?database-uri=%s&database-uri=%s&precedence=b", FEDERATED_SCHEME, url.QueryEscape(member_uri("a")), url.QueryEscape(member_uri("b")))
+
+	db, err := database.NewSpatialDatabase(ctx, db_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create federated spatial database for %s, %v", db_uri, err)
+	}
+
+	defer db.Disconnect(ctx)
+
+	federated_db := db.(*FederatedSpatialDatabase)
+
+	if federated_db.members[0].name != "b" {
+		t.Fatalf("Unexpected precedence, expected b to be first")
+	}
+
+	rsp, err := db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	ids := func(results []string) []string {
+		slices.Sort(results)
+		return results
+	}
+
+	expected := make([]string, 0)
+
+	for _, r := range single_rsp.Results() {
+		expected = append(expected, r.Id())
+	}
+
+	found := make([]string, 0)
+
+	for _, r := range rsp.Results() {
+		found = append(found, r.Id())
+	}
+
+	if !slices.Equal(ids(found), ids(expected)) {
+		t.Fatalf("Unexpected results %v, expected %v", found, expected)
+	}
+
+	// Stopping early should not leave any queries behind
+
+	count := 0
+
+	for _, err := range db.IntersectsWithIterator(ctx, orb.Bound{Min: pt, Max: pt}.Pad(0.001)) {
+
+		if err != nil {
+			t.Fatalf("Failed to perform intersects query, %v", err)
+		}
+
+		count += 1
+		break
+	}
+
+	if len(expected) > 0 && count != 1 {
+		t.Fatalf("Unexpected count (%d), expected 1", count)
+	}
+
+	invalid := []string{
+		fmt.Sprintf("%s://", FEDERATED_SCHEME),
+		fmt.Sprintf("%s://?database-uri=%s&database-uri=%s", FEDERATED_SCHEME, url.QueryEscape(member_uri("a")), url.QueryEscape(member_uri("a"))),
+		fmt.Sprintf("%s://?database-uri=%s&precedence=c", FEDERATED_SCHEME, url.QueryEscape(member_uri("a"))),
+		fmt.Sprintf("%s://?database-uri=%s", FEDERATED_SCHEME, url.QueryEscape("rtree://")),
+	}
+
+	for _, uri := range invalid {
+
+		_, err := database.NewSpatialDatabase(ctx, uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", uri)
+		}
+	}
+}
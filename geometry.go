@@ -0,0 +1,145 @@
+package pmtiles
+
+import (
+	"github.com/paulmach/orb"
+)
+
+// polygonalGeometry returns the polygonal parts of 'geom' with any rings that have collapsed to a line or a point
+// removed. If 'geom' contains no polygonal area the method returns nil. The second return value signals whether
+// the geometry returned differs from 'geom'.
+func polygonalGeometry(geom orb.Geometry) (orb.Geometry, bool) {
+
+	if geom == nil {
+		return nil, false
+	}
+
+	switch geom.GeoJSONType() {
+	case "Polygon":
+
+		poly := geom.(orb.Polygon)
+		pruned, changed := pruneDegenerateRings(poly)
+
+		if pruned == nil {
+			return nil, true
+		}
+
+		if !changed {
+			return geom, false
+		}
+
+		return pruned, true
+
+	case "MultiPolygon":
+
+		mp := geom.(orb.MultiPolygon)
+		pruned_mp := make(orb.MultiPolygon, 0, len(mp))
+
+		changed := false
+
+		for _, poly := range mp {
+
+			pruned, poly_changed := pruneDegenerateRings(poly)
+
+			if poly_changed {
+				changed = true
+			}
+
+			if pruned == nil {
+				continue
+			}
+
+			pruned_mp = append(pruned_mp, pruned)
+		}
+
+		if len(pruned_mp) == 0 {
+			return nil, true
+		}
+
+		if !changed {
+			return geom, false
+		}
+
+		return pruned_mp, true
+
+	default:
+		return nil, true
+	}
+}
+
+// pruneDegenerateRings removes any rings with a zero-area bounding box from 'poly'. It returns nil if the exterior
+// ring is degenerate and a boolean flag signaling whether any rings were removed.
+func pruneDegenerateRings(poly orb.Polygon) (orb.Polygon, bool) {
+
+	if len(poly) == 0 || isDegenerateRing(poly[0]) {
+		return nil, true
+	}
+
+	pruned := orb.Polygon{poly[0]}
+
+	for _, ring := range poly[1:] {
+
+		if isDegenerateRing(ring) {
+			continue
+		}
+
+		pruned = append(pruned, ring)
+	}
+
+	return pruned, len(pruned) != len(poly)
+}
+
+func isDegenerateRing(ring orb.Ring) bool {
+
+	if len(ring) < 4 {
+		return true
+	}
+
+	b := ring.Bound()
+
+	return b.Max.X() <= b.Min.X() || b.Max.Y() <= b.Min.Y()
+}
+
+// geometryPointCount returns the total number of points in 'geom'.
+func geometryPointCount(geom orb.Geometry) int {
+
+	count := 0
+
+	switch g := geom.(type) {
+	case orb.Point:
+		count = 1
+	case orb.MultiPoint:
+		count = len(g)
+	case orb.LineString:
+		count = len(g)
+	case orb.MultiLineString:
+
+		for _, ls := range g {
+			count += len(ls)
+		}
+
+	case orb.Ring:
+		count = len(g)
+	case orb.Polygon:
+
+		for _, ring := range g {
+			count += len(ring)
+		}
+
+	case orb.MultiPolygon:
+
+		for _, poly := range g {
+			count += geometryPointCount(poly)
+		}
+
+	case orb.Collection:
+
+		for _, c := range g {
+			count += geometryPointCount(c)
+		}
+
+	default:
+		// pass
+	}
+
+	return count
+}
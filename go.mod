@@ -12,6 +12,7 @@ require (
 	github.com/aaronland/gocloud-docstore v0.0.9
 	github.com/json-iterator/go v1.1.12
 	github.com/paulmach/orb v0.11.1
+	github.com/peterstace/simplefeatures v0.54.0
 	github.com/protomaps/go-pmtiles v1.28.0
 	github.com/sfomuseum/go-database v0.0.14
 	github.com/tidwall/gjson v1.18.0
@@ -132,7 +133,6 @@ require (
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
 	github.com/paulmach/protoscan v0.2.1 // indirect
-	github.com/peterstace/simplefeatures v0.54.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/planetscale/vtprotobuf v0.6.1-0.20240319094008-0393e58bdf10 // indirect
 	github.com/prometheus/client_golang v1.22.0 // indirect
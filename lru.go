@@ -0,0 +1,137 @@
+package pmtiles
+
+// Simple package to track the order in which (named) items were last used along
+// with their (estimated) size. It does not store the items themselves, nor does it
+// evict anything. That is left to the code using it which will know whether or not
+// an item is still in use.
+
+import (
+	"container/list"
+	"iter"
+	"sync"
+)
+
+type LRU struct {
+	list     *list.List
+	elements map[string]*list.Element
+	size     int64
+	mu       *sync.Mutex
+}
+
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+func NewLRU() *LRU {
+
+	l := &LRU{
+		list:     list.New(),
+		elements: make(map[string]*list.Element),
+		size:     int64(0),
+		mu:       new(sync.Mutex),
+	}
+
+	return l
+}
+
+// Add adds 'key' with an estimated size of 'size' as the most recently used item. If 'key'
+// already exists its size is updated.
+func (l *LRU) Add(key string, size int64) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, exists := l.elements[key]
+
+	if exists {
+		e := el.Value.(*lruEntry)
+		l.size += size - e.size
+		e.size = size
+		l.list.MoveToFront(el)
+		return
+	}
+
+	e := &lruEntry{
+		key:  key,
+		size: size,
+	}
+
+	l.elements[key] = l.list.PushFront(e)
+	l.size += size
+}
+
+// Touch marks 'key' as the most recently used item.
+func (l *LRU) Touch(key string) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, exists := l.elements[key]
+
+	if exists {
+		l.list.MoveToFront(el)
+	}
+}
+
+// Remove removes 'key'.
+func (l *LRU) Remove(key string) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, exists := l.elements[key]
+
+	if !exists {
+		return
+	}
+
+	e := el.Value.(*lruEntry)
+	l.size -= e.size
+
+	l.list.Remove(el)
+	delete(l.elements, key)
+}
+
+// Len returns the number of items being tracked.
+func (l *LRU) Len() int {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.list.Len()
+}
+
+// Size returns the sum of the estimated sizes of all the items being tracked.
+func (l *LRU) Size() int64 {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.size
+}
+
+// Oldest returns an iterator of keys ordered from least to most recently used. The
+// order is determined when the method is invoked.
+func (l *LRU) Oldest() iter.Seq[string] {
+
+	l.mu.Lock()
+
+	keys := make([]string, 0, l.list.Len())
+
+	for el := l.list.Back(); el != nil; el = el.Prev() {
+		keys = append(keys, el.Value.(*lruEntry).key)
+	}
+
+	l.mu.Unlock()
+
+	return func(yield func(string) bool) {
+
+		for _, k := range keys {
+
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
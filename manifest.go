@@ -0,0 +1,327 @@
+package pmtiles
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/protomaps/go-pmtiles/pmtiles"
+)
+
+// max_manifest_size is the maximum size, in bytes, of a manifest file.
+const max_manifest_size int64 = 1024 * 1024
+
+// Manifest maps regions of the world to the PMTiles databases ("shards") containing the tiles for those regions. It
+// is stored as a JSON-encoded file in the same bucket as the databases and allows a single `PMTilesSpatialDatabase`
+// instance to read tiles from regional databases which can be produced, and updated, independently of one another.
+type Manifest struct {
+	// The list of shards. When a tile is covered by more than one shard the first shard in the list is used.
+	Shards []*ManifestShard `json:"shards"`
+}
+
+// ManifestShard maps a region of the world to a PMTiles database. A tile belongs to a shard if its center is
+// contained by the shard's bounds or its tile range.
+type ManifestShard struct {
+	// The name of the PMTiles database, without a ".pmtiles" extension.
+	Database string `json:"database"`
+	// The bounding box of the region as [min_x, min_y, max_x, max_y] in decimal degrees.
+	Bounds []float64 `json:"bounds,omitempty"`
+	// The range of tiles, at a given zoom level, covering the region.
+	Tiles *ManifestTileRange `json:"tiles,omitempty"`
+}
+
+// ManifestTileRange is a range of tiles at a given zoom level.
+type ManifestTileRange struct {
+	Zoom uint32 `json:"zoom"`
+	MinX uint32 `json:"min_x"`
+	MinY uint32 `json:"min_y"`
+	MaxX uint32 `json:"max_x"`
+	MaxY uint32 `json:"max_y"`
+}
+
+// Validate ensures that the manifest defines at least one shard and that every shard defines a database and a
+// valid bounding box or tile range.
+func (m *Manifest) Validate() error {
+
+	if len(m.Shards) == 0 {
+		return fmt.Errorf("Manifest does not define any shards")
+	}
+
+	for i, s := range m.Shards {
+
+		if s.Database == "" {
+			return fmt.Errorf("Shard at offset %d is missing a database", i)
+		}
+
+		if s.Bounds == nil && s.Tiles == nil {
+			return fmt.Errorf("Shard for %s does not define bounds or tiles", s.Database)
+		}
+
+		if s.Bounds != nil {
+
+			if len(s.Bounds) != 4 || s.Bounds[0] > s.Bounds[2] || s.Bounds[1] > s.Bounds[3] {
+				return fmt.Errorf("Shard for %s has invalid bounds", s.Database)
+			}
+		}
+
+		if s.Tiles != nil {
+
+			if s.Tiles.Zoom > 30 || s.Tiles.MinX > s.Tiles.MaxX || s.Tiles.MinY > s.Tiles.MaxY {
+				return fmt.Errorf("Shard for %s has an invalid tile range", s.Database)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Databases returns the sorted, unique list of databases named by the manifest's shards.
+func (m *Manifest) Databases() []string {
+
+	databases := make(map[string]bool)
+
+	for _, s := range m.Shards {
+		databases[s.Database] = true
+	}
+
+	return slices.Sorted(maps.Keys(databases))
+}
+
+// ShardForTile returns the shard containing 't' and a boolean value indicating whether one was found.
+func (m *Manifest) ShardForTile(t maptile.Tile) (*ManifestShard, bool) {
+
+	center := t.Bound().Center()
+
+	for _, s := range m.Shards {
+
+		if s.contains(center) {
+			return s, true
+		}
+	}
+
+	return nil, false
+}
+
+// contains reports whether 'pt' is contained by the bounds or tile range of 's'.
+func (s *ManifestShard) contains(pt orb.Point) bool {
+
+	if s.Bounds != nil {
+
+		b := orb.Bound{
+			Min: orb.Point{s.Bounds[0], s.Bounds[1]},
+			Max: orb.Point{s.Bounds[2], s.Bounds[3]},
+		}
+
+		if b.Contains(pt) {
+			return true
+		}
+	}
+
+	if s.Tiles != nil {
+
+		t := maptile.At(pt, maptile.Zoom(s.Tiles.Zoom))
+
+		if t.X >= s.Tiles.MinX && t.X <= s.Tiles.MaxX && t.Y >= s.Tiles.MinY && t.Y <= s.Tiles.MaxY {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readManifest reads, and validates, the manifest 'key' in 'bucket'. It returns the manifest along with a string
+// which changes whenever the manifest does.
+func readManifest(ctx context.Context, bucket pmtiles.Bucket, key string) (*Manifest, string, error) {
+
+	r, etag, _, err := bucket.NewRangeReaderEtag(ctx, key, 0, max_manifest_size, "")
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to open manifest %s, %w", key, err)
+	}
+
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to read manifest %s, %w", key, err)
+	}
+
+	if int64(len(body)) >= max_manifest_size {
+		return nil, "", fmt.Errorf("Manifest %s exceeds maximum size of %d bytes", key, max_manifest_size)
+	}
+
+	var m *Manifest
+
+	err = json.Unmarshal(body, &m)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to unmarshal manifest %s, %w", key, err)
+	}
+
+	err = m.Validate()
+
+	if err != nil {
+		return nil, "", fmt.Errorf("Invalid manifest %s, %w", key, err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(etag))
+	h.Write(body)
+
+	return m, fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// tileRouter determines which PMTiles database the tiles being queried are read from. A `PMTilesSpatialDatabase`
+// replaces its router, rather than modifying it, whenever its manifest (or any of its databases) changes so that
+// queries already in progress are unaffected. See reload.go for details.
+type tileRouter struct {
+	// The database named by the ?database= parameter. It is used for tiles not covered by the manifest, if present.
+	primary *tileArchive
+	// The manifest named by the ?manifest= parameter, if present.
+	manifest *Manifest
+	// A string which changes whenever the manifest does.
+	manifest_id string
+	// The databases named by the manifest's shards.
+	shards map[string]*tileArchive
+	// The generation is incremented every time the router is replaced. It is used to distinguish the per-tile
+	// spatial databases created using one router from those created using another.
+	generation int64
+	// A string derived from the identities of all the databases. It is assigned to the features added to the
+	// feature cache so that features cached from a different set of databases can be ignored.
+	epoch string
+	// The number of queries currently being performed using the router.
+	queries atomic.Int64
+}
+
+// archiveForTile returns the database that 't' should be read from or nil if there is none.
+func (r *tileRouter) archiveForTile(t maptile.Tile) *tileArchive {
+
+	if r.manifest != nil {
+
+		s, exists := r.manifest.ShardForTile(t)
+
+		if exists {
+			return r.shards[s.Database]
+		}
+	}
+
+	return r.primary
+}
+
+// archive returns the database named 'name' and a boolean value indicating whether it was found.
+func (r *tileRouter) archive(name string) (*tileArchive, bool) {
+
+	if r.primary != nil && r.primary.name == name {
+		return r.primary, true
+	}
+
+	a, exists := r.shards[name]
+	return a, exists
+}
+
+// archives returns all the databases known to 'r'.
+func (r *tileRouter) archives() []*tileArchive {
+
+	archives := make([]*tileArchive, 0)
+
+	if r.primary != nil {
+		archives = append(archives, r.primary)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(r.shards)) {
+		archives = append(archives, r.shards[name])
+	}
+
+	return archives
+}
+
+// newTileRouter returns a new `tileRouter` for 'primary' (which may be nil) and 'manifest' (which may also be nil),
+// loading the databases named by the manifest. Databases which have already been loaded, keyed by name in 'loaded',
+// are reused.
+func (db *PMTilesSpatialDatabase) newTileRouter(ctx context.Context, primary *tileArchive, manifest *Manifest, manifest_id string, loaded map[string]*tileArchive, generation int64) (*tileRouter, error) {
+
+	r := &tileRouter{
+		primary:     primary,
+		manifest:    manifest,
+		manifest_id: manifest_id,
+		shards:      make(map[string]*tileArchive),
+		generation:  generation,
+	}
+
+	if manifest != nil {
+
+		for _, name := range manifest.Databases() {
+
+			a, exists := loaded[name]
+
+			if exists {
+				r.shards[name] = a
+				continue
+			}
+
+			a, err := loadTileArchive(ctx, db.bucket, name, db.layers)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to load database for shard, %w", err)
+			}
+
+			r.shards[name] = a
+		}
+	}
+
+	archives := r.archives()
+
+	if len(archives) == 0 {
+		return nil, fmt.Errorf("No databases to read tiles from")
+	}
+
+	h := sha256.New()
+
+	for _, a := range archives {
+		h.Write([]byte(a.id))
+	}
+
+	r.epoch = fmt.Sprintf("%x", h.Sum(nil))[:16]
+	return r, nil
+}
+
+// maxZoom returns the highest maximum zoom level at which tiles are stored in any of the databases known to 'r'.
+func (r *tileRouter) maxZoom() int {
+
+	max_zoom := 0
+
+	for _, a := range r.archives() {
+		max_zoom = max(max_zoom, a.max_zoom)
+	}
+
+	return max_zoom
+}
+
+// validateZoom ensures that tiles at 'zoom', defined by the query parameter 'param', can be read from all the
+// databases known to 'r'. If 'zoom' is greater than the maximum zoom level of a database then features are read from
+// overzoomed tiles (see featuresForTile).
+func (r *tileRouter) validateZoom(param string, zoom int, layers []string) error {
+
+	for _, a := range r.archives() {
+
+		if zoom < a.min_zoom {
+			return fmt.Errorf("Invalid ?%s= parameter, %d is less than the minimum zoom (%d) of the %s layer(s) in the %s database", param, zoom, a.min_zoom, strings.Join(layers, ", "), a.name)
+		}
+
+		if zoom > a.max_zoom {
+			slog.Info("Zoom level exceeds maximum zoom of database, features will be read from overzoomed tiles", "database", a.name, "zoom", zoom, "max zoom", a.max_zoom)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+package pmtiles
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestManifestShardForTile(t *testing.T) {
+
+	m := &Manifest{
+		Shards: []*ManifestShard{
+			&ManifestShard{Database: "sf", Bounds: []float64{-122.6, 37.6, -122.3, 37.9}},
+			&ManifestShard{Database: "west", Tiles: &ManifestTileRange{Zoom: 1, MinX: 0, MinY: 0, MaxX: 0, MaxY: 1}},
+		},
+	}
+
+	err := m.Validate()
+
+	if err != nil {
+		t.Fatalf("Failed to validate manifest, %v", err)
+	}
+
+	tests := map[orb.Point]string{
+		orb.Point{-122.414647, 37.759415}: "sf",
+		orb.Point{-73.9, 40.7}:            "west",
+		orb.Point{2.35, 48.85}:            "",
+	}
+
+	for pt, expected := range tests {
+
+		tile := maptile.At(pt, 13)
+
+		s, exists := m.ShardForTile(tile)
+
+		if expected == "" {
+
+			if exists {
+				t.Fatalf("Expected %v not to be covered by any shard, got %s", pt, s.Database)
+			}
+
+			continue
+		}
+
+		if !exists || s.Database != expected {
+			t.Fatalf("Expected %v to be covered by %s", pt, expected)
+		}
+	}
+
+	invalid := []*Manifest{
+		&Manifest{},
+		&Manifest{Shards: []*ManifestShard{&ManifestShard{Bounds: []float64{0, 0, 1, 1}}}},
+		&Manifest{Shards: []*ManifestShard{&ManifestShard{Database: "sf"}}},
+		&Manifest{Shards: []*ManifestShard{&ManifestShard{Database: "sf", Bounds: []float64{1, 1, 0, 0}}}},
+	}
+
+	for i, m := range invalid {
+
+		err := m.Validate()
+
+		if err == nil {
+			t.Fatalf("Expected manifest at offset %d to be invalid", i)
+		}
+	}
+}
+
+func TestPointInPolygonWithManifest(t *testing.T) {
+
+	abs_path := testFixturePath(t)
+
+	root := t.TempDir()
+
+	err := os.Symlink(abs_path, filepath.Join(root, "sf.pmtiles"))
+
+	if err != nil {
+		t.Fatalf("Failed to create symlink for fixture, %v", err)
+	}
+
+	manifest_path := filepath.Join(root, "manifest.json")
+
+	write_manifest := func(bounds string) {
+
+		body := fmt.Sprintf(`{"shards":[{"database":"sf","bounds":%s}]}`, bounds)
+
+		err := os.WriteFile(manifest_path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write manifest, %v", err)
+		}
+	}
+
+	write_manifest("[-122.6,37.6,-122.3,37.9]")
+
+	ctx := context.Background()
+
+	pt := orb.Point{-122.414647, 37.759415}
+
+	direct_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&database=sf&zoom=13&layer=whosonfirst", root)
+
+	direct_db, err := database.NewSpatialDatabase(ctx, direct_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	defer direct_db.Disconnect(ctx)
+
+	direct_rsp, err := direct_db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	// Note the absence of a ?database= parameter
+
+	db_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&manifest=manifest.json&zoom=13&layer=whosonfirst", root)
+
+	db, err := database.NewSpatialDatabase(ctx, db_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database for %s, %v", db_uri, err)
+	}
+
+	defer db.Disconnect(ctx)
+
+	rsp, err := db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	if len(rsp.Results()) != len(direct_rsp.Results()) {
+		t.Fatalf("Unexpected results count (%d), expected %d", len(rsp.Results()), len(direct_rsp.Results()))
+	}
+
+	// Tiles outside the manifest (and without a fallback database) have no features
+
+	outside := orb.Point{-73.9, 40.7}
+
+	rsp, err = db.PointInPolygon(ctx, &outside)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	if len(rsp.Results()) != 0 {
+		t.Fatalf("Expected no results outside manifest, got %d", len(rsp.Results()))
+	}
+
+	// Replace the manifest so that it no longer covers pt
+
+	write_manifest("[-74.3,40.5,-73.7,40.9]")
+
+	pmtiles_db := db.(*PMTilesSpatialDatabase)
+
+	err = pmtiles_db.Reload(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to reload databases, %v", err)
+	}
+
+	if pmtiles_db.router.Load().generation != 1 {
+		t.Fatalf("Expected router to be replaced")
+	}
+
+	for db_name, _ := range pmtiles_db.spatial_databases_cache {
+
+		if strings.HasPrefix(db_name, "g0-") {
+			t.Fatalf("Expected spatial database %s to have been purged", db_name)
+		}
+	}
+
+	rsp, err = db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	if len(rsp.Results()) != 0 {
+		t.Fatalf("Expected no results after manifest was replaced, got %d", len(rsp.Results()))
+	}
+
+	// An invalid manifest leaves the current router in place
+
+	err = os.WriteFile(manifest_path, []byte(`{"shards":[]}`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write manifest, %v", err)
+	}
+
+	err = pmtiles_db.Reload(ctx)
+
+	if err == nil {
+		t.Fatalf("Expected reloading an invalid manifest to fail")
+	}
+
+	if pmtiles_db.router.Load().generation != 1 {
+		t.Fatalf("Expected router to be unchanged")
+	}
+}
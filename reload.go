@@ -0,0 +1,278 @@
+package pmtiles
+
+// Detect, and switch to, PMTiles databases (and manifests) which have been replaced without restarting. Queries
+// are performed using a `tileRouter` which is replaced, rather than modified, whenever anything changes. Queries
+// already in progress finish using the router they started with and the per-tile spatial databases created using
+// that router are removed once they are no longer being queried. Features added to the feature cache are stamped
+// with the router's epoch so that features cached from the previous databases are ignored.
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reload_timeout is the maximum amount of time that reloads triggered by the ?reload-interval= or ?reload-on-sighup=
+// parameters may take, including waiting for queries against the previous databases to complete.
+const reload_timeout time.Duration = 60 * time.Second
+
+// Reload reads the manifest, if present, and the headers of all the PMTiles databases again. If any of them have
+// changed (for example because a database has been replaced by a new file with the same name) then queries are
+// switched to the new databases. Reload waits for any queries still being performed against the previous databases
+// to complete, or for 'ctx' to be cancelled, before removing the tile databases derived from them. If the manifest
+// or any of the databases can not be read, or are invalid, the current databases remain in use and an error is
+// returned.
+func (db *PMTilesSpatialDatabase) Reload(ctx context.Context) error {
+
+	// The router is replaced while holding the lock but the previous router is drained
+	// after releasing it so that other reloads are not blocked waiting for queries to
+	// complete.
+
+	current, err := db.replaceTileRouter(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		return nil
+	}
+
+	defer db.purgeSpatialDatabases(ctx)
+
+	err = drainTileRouter(ctx, current)
+
+	if err != nil {
+		return fmt.Errorf("Databases reloaded but failed to wait for queries against previous databases to complete, %w", err)
+	}
+
+	return nil
+}
+
+// replaceTileRouter reads the manifest, if present, and the headers of all the PMTiles databases again and, if any
+// of them have changed, replaces the current router returning the router it replaced. If nothing has changed it
+// returns nil.
+func (db *PMTilesSpatialDatabase) replaceTileRouter(ctx context.Context) (*tileRouter, error) {
+
+	db.router_mutex.Lock()
+	defer db.router_mutex.Unlock()
+
+	current := db.router.Load()
+
+	manifest := current.manifest
+	manifest_id := current.manifest_id
+
+	if db.manifest != "" {
+
+		m, m_id, err := readManifest(ctx, db.bucket, db.manifest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		manifest = m
+		manifest_id = m_id
+	}
+
+	names := make([]string, 0)
+
+	if current.primary != nil {
+		names = append(names, current.primary.name)
+	}
+
+	if manifest != nil {
+		names = append(names, manifest.Databases()...)
+	}
+
+	changed := manifest_id != current.manifest_id
+
+	loaded := make(map[string]*tileArchive)
+
+	for _, name := range names {
+
+		_, exists := loaded[name]
+
+		if exists {
+			continue
+		}
+
+		a, err := loadTileArchive(ctx, db.bucket, name, db.layers)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to reload database, %w", err)
+		}
+
+		prev, exists := current.archive(name)
+
+		if exists && prev.id == a.id {
+			a = prev
+		} else {
+			slog.Info("Database has changed", "database", name)
+			changed = true
+		}
+
+		loaded[name] = a
+	}
+
+	if !changed {
+		return nil, nil
+	}
+
+	var primary *tileArchive
+
+	if current.primary != nil {
+		primary = loaded[current.primary.name]
+	}
+
+	r, err := db.newTileRouter(ctx, primary, manifest, manifest_id, loaded, current.generation+1)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.validateZoom("zoom", db.zoom, db.layers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if db.intersects_min_zoom != db.zoom {
+
+		err = r.validateZoom("intersects-min-zoom", db.intersects_min_zoom, db.layers)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.router.Store(r)
+
+	slog.Info("Databases reloaded", "generation", r.generation, "databases", len(r.archives()))
+
+	return current, nil
+}
+
+// acquireTileRouter returns the current router, incrementing the number of queries being performed using it.
+// Callers are expected to invoke releaseTileRouter once they are finished.
+func (db *PMTilesSpatialDatabase) acquireTileRouter() *tileRouter {
+
+	for {
+
+		r := db.router.Load()
+		r.queries.Add(1)
+
+		// Check that the router wasn't replaced in the meantime, in which case it may
+		// already have been drained.
+
+		if db.router.Load() == r {
+			return r
+		}
+
+		r.queries.Add(-1)
+	}
+}
+
+// releaseTileRouter decrements the number of queries being performed using 'r'.
+func (db *PMTilesSpatialDatabase) releaseTileRouter(r *tileRouter) {
+	r.queries.Add(-1)
+}
+
+// drainTileRouter waits for all the queries being performed using 'r' to complete or for 'ctx' to be cancelled.
+func drainTileRouter(ctx context.Context, r *tileRouter) error {
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for r.queries.Load() > 0 {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			// pass
+		}
+	}
+
+	return nil
+}
+
+// reloadInBackground invokes the Reload method every 'interval' and, if 'on_sighup' is true, whenever the current
+// process receives a SIGHUP signal until the Disconnect method is invoked. Invoking the Disconnect method also
+// cancels any reload in progress.
+func (db *PMTilesSpatialDatabase) reloadInBackground(ctx context.Context, interval time.Duration, on_sighup bool) {
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	db.reload_cancel = cancel
+	db.reload_wg = new(sync.WaitGroup)
+
+	reload := func(trigger string) {
+
+		reload_ctx, reload_cancel := context.WithTimeout(ctx, reload_timeout)
+		defer reload_cancel()
+
+		err := db.Reload(reload_ctx)
+
+		if err != nil {
+			slog.Error("Failed to reload databases", "trigger", trigger, "error", err)
+		}
+	}
+
+	var ticker_ch <-chan time.Time
+
+	if interval > 0 {
+		db.reload_ticker = time.NewTicker(interval)
+		ticker_ch = db.reload_ticker.C
+	}
+
+	var signal_ch chan os.Signal
+
+	if on_sighup {
+		signal_ch = make(chan os.Signal, 1)
+		signal.Notify(signal_ch, syscall.SIGHUP)
+		db.reload_signals = signal_ch
+	}
+
+	db.reload_wg.Add(1)
+
+	go func() {
+
+		defer db.reload_wg.Done()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker_ch:
+				reload("interval")
+			case <-signal_ch:
+				reload("signal")
+			}
+		}
+	}()
+}
+
+// stopReloading stops the background reloads started by reloadInBackground, cancelling any reload in progress, and
+// waits for them to finish.
+func (db *PMTilesSpatialDatabase) stopReloading() {
+
+	if db.reload_cancel == nil {
+		return
+	}
+
+	if db.reload_ticker != nil {
+		db.reload_ticker.Stop()
+	}
+
+	if db.reload_signals != nil {
+		signal.Stop(db.reload_signals)
+	}
+
+	db.reload_cancel()
+	db.reload_wg.Wait()
+}
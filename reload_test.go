@@ -0,0 +1,232 @@
+package pmtiles
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestReload(t *testing.T) {
+
+	abs_path := testFixturePath(t)
+
+	body, err := os.ReadFile(abs_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", abs_path, err)
+	}
+
+	root := t.TempDir()
+	db_path := filepath.Join(root, "sf.pmtiles")
+
+	err = os.WriteFile(db_path, body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %v", db_path, err)
+	}
+
+	ctx := context.Background()
+
+	db_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&database=sf&zoom=13&layer=whosonfirst&enable-cache=true", root)
+
+	db, err := database.NewSpatialDatabase(ctx, db_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	defer db.Disconnect(ctx)
+
+	pmtiles_db := db.(*PMTilesSpatialDatabase)
+
+	pt := orb.Point{-122.414647, 37.759415}
+
+	_, err = db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	// Nothing has changed
+
+	err = pmtiles_db.Reload(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to reload databases, %v", err)
+	}
+
+	previous := pmtiles_db.router.Load()
+
+	if previous.generation != 0 {
+		t.Fatalf("Expected router to be unchanged")
+	}
+
+	// Replace the database (a new modification time is enough to change its etag) while
+	// a query is still being performed against it
+
+	then := time.Now().Add(time.Minute)
+
+	err = os.Chtimes(db_path, then, then)
+
+	if err != nil {
+		t.Fatalf("Failed to update modification time for %s, %v", db_path, err)
+	}
+
+	inflight := pmtiles_db.acquireTileRouter()
+
+	reload_ctx, reload_cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer reload_cancel()
+
+	reload_ch := make(chan error)
+
+	go func() {
+		reload_ch <- pmtiles_db.Reload(reload_ctx)
+	}()
+
+	for pmtiles_db.router.Load().generation != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Other reloads are not blocked while waiting for queries against the previous
+	// databases to complete
+
+	t1 := time.Now()
+
+	err = pmtiles_db.Reload(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to reload databases while waiting for queries to complete, %v", err)
+	}
+
+	if time.Since(t1) >= time.Second {
+		t.Fatalf("Expected reload not to wait for another reload to complete")
+	}
+
+	err = <-reload_ch
+
+	if err == nil {
+		t.Fatalf("Expected reload to time out waiting for queries to complete")
+	}
+
+	current := pmtiles_db.router.Load()
+
+	if current.generation != 1 {
+		t.Fatalf("Expected router to be replaced")
+	}
+
+	if current.epoch == previous.epoch {
+		t.Fatalf("Expected a new epoch")
+	}
+
+	pmtiles_db.releaseTileRouter(inflight)
+
+	pmtiles_db.purgeSpatialDatabases(ctx)
+
+	for db_name := range pmtiles_db.spatial_databases_cache {
+
+		if strings.HasPrefix(db_name, "g0-") {
+			t.Fatalf("Expected spatial database %s to have been purged", db_name)
+		}
+	}
+
+	for db_name := range pmtiles_db.spatial_databases_counter.lookup {
+
+		if strings.HasPrefix(db_name, "g0-") {
+			t.Fatalf("Expected reference count for spatial database %s to have been removed", db_name)
+		}
+	}
+
+	rsp, err := db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	// Features are cached again from the new database
+
+	for _, r := range rsp.Results() {
+
+		exists, err := db.Exists(ctx, r.Path())
+
+		if err != nil {
+			t.Fatalf("Failed to determine whether %s exists, %v", r.Path(), err)
+		}
+
+		if !exists {
+			t.Fatalf("Expected %s to exist in the feature cache", r.Path())
+		}
+	}
+}
+
+func TestReloadInBackgroundDisconnect(t *testing.T) {
+
+	abs_path := testFixturePath(t)
+
+	body, err := os.ReadFile(abs_path)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", abs_path, err)
+	}
+
+	root := t.TempDir()
+	db_path := filepath.Join(root, "sf.pmtiles")
+
+	err = os.WriteFile(db_path, body, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %v", db_path, err)
+	}
+
+	ctx := context.Background()
+
+	db_uri := fmt.Sprintf("pmtiles://?tiles=file://%s&database=sf&zoom=13&layer=whosonfirst&reload-interval=1", root)
+
+	db, err := database.NewSpatialDatabase(ctx, db_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create spatial database, %v", err)
+	}
+
+	pmtiles_db := db.(*PMTilesSpatialDatabase)
+
+	// Replace the database while a query is still being performed against it so that
+	// the background reload waits for the query to complete
+
+	inflight := pmtiles_db.acquireTileRouter()
+	defer pmtiles_db.releaseTileRouter(inflight)
+
+	then := time.Now().Add(time.Minute)
+
+	err = os.Chtimes(db_path, then, then)
+
+	if err != nil {
+		t.Fatalf("Failed to update modification time for %s, %v", db_path, err)
+	}
+
+	for pmtiles_db.router.Load().generation != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Disconnecting cancels the reload rather than waiting for it to time out
+
+	done_ch := make(chan bool)
+
+	go func() {
+		db.Disconnect(ctx)
+		done_ch <- true
+	}()
+
+	select {
+	case <-done_ch:
+		// pass
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for database to disconnect")
+	}
+}
@@ -0,0 +1,345 @@
+package pmtiles
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// tile_cache_native_ext is the extension of the files used to store the features indexed by native://
+	// tile databases.
+	tile_cache_native_ext string = ".gob"
+	// tile_cache_sqlite_ext is the extension of the files used to store sqlite:// tile databases.
+	tile_cache_sqlite_ext string = ".db"
+	// tile_cache_temp_prefix is the prefix of the temporary files used to build tile databases.
+	tile_cache_temp_prefix string = ".tile-"
+)
+
+// tile_cache_extensions are the extensions of the files accounted for by a `TileCache` instance.
+var tile_cache_extensions = []string{
+	tile_cache_native_ext,
+	tile_cache_sqlite_ext,
+}
+
+// TileCache is an on-disk cache, which survives restarts, of the per-tile spatial databases built from the tiles
+// in one or more PMTiles archives. The least recently used databases are removed when the cache exceeds its size.
+type TileCache struct {
+	root       string
+	archive_id string
+	variant    string
+	max_size   int64
+	lru        *LRU
+	mu         *sync.Mutex
+}
+
+// NewTileCache returns a new `TileCache` instance storing tile databases for the archive 'archive_id', built using
+// the options identified by 'variant', in 'root'. A 'max_size' of 0 (bytes) means there is no limit.
+func NewTileCache(ctx context.Context, root string, archive_id string, variant string, max_size int64) (*TileCache, error) {
+
+	if archive_id == "" {
+		return nil, fmt.Errorf("Missing archive identifier")
+	}
+
+	if variant == "" {
+		return nil, fmt.Errorf("Missing variant")
+	}
+
+	abs_root, err := filepath.Abs(root)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive absolute path for %s, %w", root, err)
+	}
+
+	err = os.MkdirAll(abs_root, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s, %w", abs_root, err)
+	}
+
+	c := &TileCache{
+		root:       abs_root,
+		archive_id: archive_id,
+		variant:    variant,
+		max_size:   max_size,
+		lru:        NewLRU(),
+		mu:         new(sync.Mutex),
+	}
+
+	err = c.load(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// load tracks the tile databases already in the cache directory, oldest first, ignoring any temporary files.
+func (c *TileCache) load(ctx context.Context) error {
+
+	type cachedTile struct {
+		key     string
+		size    int64
+		modtime time.Time
+	}
+
+	tiles := make([]*cachedTile, 0)
+
+	walk_func := func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), tile_cache_temp_prefix) {
+			return nil
+		}
+
+		if !slices.Contains(tile_cache_extensions, filepath.Ext(path)) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		key, err := filepath.Rel(c.root, path)
+
+		if err != nil {
+			return err
+		}
+
+		t := &cachedTile{
+			key:     key,
+			size:    info.Size(),
+			modtime: info.ModTime(),
+		}
+
+		tiles = append(tiles, t)
+		return nil
+	}
+
+	err := filepath.WalkDir(c.root, walk_func)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read tile cache %s, %w", c.root, err)
+	}
+
+	slices.SortFunc(tiles, func(a *cachedTile, b *cachedTile) int {
+		return a.modtime.Compare(b.modtime)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, t := range tiles {
+		c.lru.Add(t.key, t.size)
+	}
+
+	c.evict(ctx)
+	return nil
+}
+
+// Get returns the contents of the file with extension 'ext' for the tile at 'z', 'x' and 'y' and a boolean value
+// indicating whether the file was found in the cache.
+func (c *TileCache) Get(ctx context.Context, z uint8, x uint32, y uint32, ext string) ([]byte, bool, error) {
+
+	path, exists, err := c.Open(ctx, z, x, y, ext)
+
+	if err != nil || !exists {
+		return nil, exists, err
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	return body, true, nil
+}
+
+// Open returns the path of the file with extension 'ext' for the tile at 'z', 'x' and 'y', marking it as the most
+// recently used file, and a boolean value indicating whether the file was found in the cache.
+func (c *TileCache) Open(ctx context.Context, z uint8, x uint32, y uint32, ext string) (string, bool, error) {
+
+	key := c.key(z, x, y, ext)
+	path := filepath.Join(c.root, key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+
+		if os.IsNotExist(err) {
+			c.lru.Remove(key)
+			return "", false, nil
+		}
+
+		return "", false, fmt.Errorf("Failed to stat %s, %w", path, err)
+	}
+
+	// Update the modification time so that the order in which files were used
+	// is preserved across restarts.
+
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+
+	if err != nil {
+		slog.Debug("Failed to update modification time for cached tile database", "path", path, "error", err)
+	}
+
+	c.lru.Add(key, info.Size())
+	return path, true, nil
+}
+
+// Set stores 'body' as the contents of the file with extension 'ext' for the tile at 'z', 'x' and 'y' removing the
+// least recently used files if the cache now exceeds its maximum size.
+func (c *TileCache) Set(ctx context.Context, z uint8, x uint32, y uint32, ext string, body []byte) error {
+
+	tmp_path, err := c.CreateTemp(z, x, y, ext)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(tmp_path, body, 0644)
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return fmt.Errorf("Failed to write %s, %w", tmp_path, err)
+	}
+
+	return c.Commit(ctx, z, x, y, ext, tmp_path)
+}
+
+// CreateTemp returns the path of a new, empty file in which to build the tile database for 'z', 'x' and 'y' before
+// adding it to the cache using the Commit method. Temporary files are not accounted for until they are committed.
+func (c *TileCache) CreateTemp(z uint8, x uint32, y uint32, ext string) (string, error) {
+
+	path := filepath.Join(c.root, c.key(z, x, y, ext))
+	root := filepath.Dir(path)
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to create %s, %w", root, err)
+	}
+
+	wr, err := os.CreateTemp(root, tile_cache_temp_prefix+"*"+ext)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to create temporary file in %s, %w", root, err)
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		os.Remove(wr.Name())
+		return "", fmt.Errorf("Failed to close %s, %w", wr.Name(), err)
+	}
+
+	return wr.Name(), nil
+}
+
+// Commit moves the temporary file 'tmp_path', created using the CreateTemp method, to the file with extension 'ext'
+// for the tile at 'z', 'x' and 'y' removing the least recently used files if the cache now exceeds its maximum size.
+func (c *TileCache) Commit(ctx context.Context, z uint8, x uint32, y uint32, ext string, tmp_path string) error {
+
+	key := c.key(z, x, y, ext)
+	path := filepath.Join(c.root, key)
+
+	info, err := os.Stat(tmp_path)
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return fmt.Errorf("Failed to stat %s, %w", tmp_path, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = os.Rename(tmp_path, path)
+
+	if err != nil {
+		os.Remove(tmp_path)
+		return fmt.Errorf("Failed to rename %s, %w", tmp_path, err)
+	}
+
+	c.lru.Add(key, info.Size())
+	c.evict(ctx)
+
+	return nil
+}
+
+// ForArchive returns a `TileCache` instance for the archive 'archive_id' which shares the directory, list of files
+// and maximum size of 'c'.
+func (c *TileCache) ForArchive(archive_id string) *TileCache {
+
+	if archive_id == c.archive_id {
+		return c
+	}
+
+	archive_c := &TileCache{
+		root:       c.root,
+		archive_id: archive_id,
+		variant:    c.variant,
+		max_size:   c.max_size,
+		lru:        c.lru,
+		mu:         c.mu,
+	}
+
+	return archive_c
+}
+
+// Size returns the combined size, in bytes, of all the files in the cache.
+func (c *TileCache) Size() int64 {
+	return c.lru.Size()
+}
+
+// key returns the path, relative to the root of the cache, of the file with extension 'ext' for 'z', 'x' and 'y'.
+func (c *TileCache) key(z uint8, x uint32, y uint32, ext string) string {
+	return filepath.Join(c.archive_id, c.variant, fmt.Sprintf("%d", z), fmt.Sprintf("%d", x), fmt.Sprintf("%d%s", y, ext))
+}
+
+// evict removes the least recently used files until the cache no longer exceeds its maximum size. The most recently
+// used file is always kept so that it can be opened. It is assumed that the caller has acquired a lock on c.mu.
+func (c *TileCache) evict(ctx context.Context) {
+
+	if c.max_size <= 0 || c.lru.Size() <= c.max_size {
+		return
+	}
+
+	evicted := 0
+
+	for key := range c.lru.Oldest() {
+
+		if c.lru.Size() <= c.max_size || c.lru.Len() <= 1 {
+			break
+		}
+
+		path := filepath.Join(c.root, key)
+		err := os.Remove(path)
+
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove cached tile database", "path", path, "error", err)
+		}
+
+		c.lru.Remove(key)
+		evicted += 1
+	}
+
+	slog.Debug("Evict cached tile databases", "evicted", evicted, "count", c.lru.Len(), "size", c.lru.Size())
+}
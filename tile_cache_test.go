@@ -0,0 +1,200 @@
+package pmtiles
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTileCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	c, err := NewTileCache(ctx, root, "a", "v", 10)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	_, exists, err := c.Get(ctx, 12, 1, 1, tile_cache_native_ext)
+
+	if err != nil {
+		t.Fatalf("Failed to get tile, %v", err)
+	}
+
+	if exists {
+		t.Fatalf("Expected tile to be absent")
+	}
+
+	err = c.Set(ctx, 12, 1, 1, tile_cache_native_ext, []byte("hello"))
+
+	if err != nil {
+		t.Fatalf("Failed to set tile, %v", err)
+	}
+
+	err = c.Set(ctx, 12, 1, 2, tile_cache_native_ext, []byte("world"))
+
+	if err != nil {
+		t.Fatalf("Failed to set tile, %v", err)
+	}
+
+	// Mark 1/1 as the most recently used tile so that 1/2 is evicted below
+
+	body, exists, err := c.Get(ctx, 12, 1, 1, tile_cache_native_ext)
+
+	if err != nil {
+		t.Fatalf("Failed to get tile, %v", err)
+	}
+
+	if !exists || !bytes.Equal(body, []byte("hello")) {
+		t.Fatalf("Unexpected tile data '%s'", body)
+	}
+
+	err = c.Set(ctx, 12, 1, 3, tile_cache_native_ext, []byte("!"))
+
+	if err != nil {
+		t.Fatalf("Failed to set tile, %v", err)
+	}
+
+	if c.Size() > 10 {
+		t.Fatalf("Unexpected size %d", c.Size())
+	}
+
+	_, exists, _ = c.Get(ctx, 12, 1, 2, tile_cache_native_ext)
+
+	if exists {
+		t.Fatalf("Expected tile 12/1/2 to have been evicted")
+	}
+
+	// Tiles should be reused by a new cache for the same archive but not a different one
+
+	c2, err := NewTileCache(ctx, root, "a", "v", 10)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	if c2.Size() != c.Size() {
+		t.Fatalf("Unexpected size %d, expected %d", c2.Size(), c.Size())
+	}
+
+	_, exists, _ = c2.Get(ctx, 12, 1, 1, tile_cache_native_ext)
+
+	if !exists {
+		t.Fatalf("Expected tile 12/1/1 to exist")
+	}
+
+	c3, err := NewTileCache(ctx, root, "b", "v", 10)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	_, exists, _ = c3.Get(ctx, 12, 1, 1, tile_cache_native_ext)
+
+	if exists {
+		t.Fatalf("Expected tile 12/1/1 to be absent for a different archive")
+	}
+
+	// Caches for other archives derived from the same cache share its size limit
+
+	c4 := c2.ForArchive("b")
+
+	_, exists, _ = c4.Get(ctx, 12, 1, 1, tile_cache_native_ext)
+
+	if exists {
+		t.Fatalf("Expected tile 12/1/1 to be absent for a different archive")
+	}
+
+	err = c4.Set(ctx, 12, 1, 1, tile_cache_native_ext, []byte("0123456789"))
+
+	if err != nil {
+		t.Fatalf("Failed to set tile, %v", err)
+	}
+
+	if c2.Size() > 10 {
+		t.Fatalf("Unexpected size %d", c2.Size())
+	}
+
+	_, exists, _ = c2.Get(ctx, 12, 1, 1, tile_cache_native_ext)
+
+	if exists {
+		t.Fatalf("Expected tile 12/1/1 to have been evicted by tile for a different archive")
+	}
+
+	// Tile databases built using different options are never shared
+
+	c5, err := NewTileCache(ctx, root, "a", "w", 10)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	_, exists, _ = c5.Get(ctx, 12, 1, 3, tile_cache_native_ext)
+
+	if exists {
+		t.Fatalf("Expected tile 12/1/3 to be absent for a different variant")
+	}
+
+	// The most recently added tile database is kept even if it exceeds the maximum size on its own
+
+	err = c5.Set(ctx, 12, 1, 4, tile_cache_native_ext, []byte("0123456789!"))
+
+	if err != nil {
+		t.Fatalf("Failed to set tile, %v", err)
+	}
+
+	_, exists, _ = c5.Get(ctx, 12, 1, 4, tile_cache_native_ext)
+
+	if !exists {
+		t.Fatalf("Expected tile 12/1/4 to exist")
+	}
+
+	// Temporary files are not accounted for until they are committed
+
+	tmp_path, err := c5.CreateTemp(12, 1, 5, tile_cache_sqlite_ext)
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary file, %v", err)
+	}
+
+	err = os.WriteFile(tmp_path, []byte("hello"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write temporary file, %v", err)
+	}
+
+	c6, err := NewTileCache(ctx, root, "a", "w", 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	if c6.Size() != 11 {
+		t.Fatalf("Unexpected size %d", c6.Size())
+	}
+
+	err = c6.Commit(ctx, 12, 1, 5, tile_cache_sqlite_ext, tmp_path)
+
+	if err != nil {
+		t.Fatalf("Failed to commit temporary file, %v", err)
+	}
+
+	path, exists, err := c6.Open(ctx, 12, 1, 5, tile_cache_sqlite_ext)
+
+	if err != nil {
+		t.Fatalf("Failed to open tile, %v", err)
+	}
+
+	if !exists || filepath.Ext(path) != tile_cache_sqlite_ext {
+		t.Fatalf("Unexpected path '%s'", path)
+	}
+
+	if c6.Size() != 16 {
+		t.Fatalf("Unexpected size %d", c6.Size())
+	}
+}
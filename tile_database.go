@@ -0,0 +1,445 @@
+package pmtiles
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+	"github.com/whosonfirst/go-whosonfirst-feature/alt"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// NATIVE_TILE_DATABASE_SCHEME is the URI scheme used to signal that the features in individual tiles should
+// be indexed using a `TileSpatialDatabase` instance.
+const NATIVE_TILE_DATABASE_SCHEME string = "native"
+
+// tile_database_encoding_version is the version of the format used to encode the features in a `TileSpatialDatabase`
+// instance. It should be incremented whenever that format changes so that features encoded using an older version are
+// ignored rather than decoded incorrectly.
+const tile_database_encoding_version int = 1
+
+func init() {
+	ctx := context.Background()
+	database.RegisterSpatialDatabase(ctx, NATIVE_TILE_DATABASE_SCHEME, NewTileSpatialDatabase)
+
+	// Feature geometries are encoded as orb.Geometry interface values, and property values
+	// as interface values which may themselves be lists or dictionaries, so the concrete
+	// types need to be registered with the gob package.
+
+	gob.Register([]any{})
+	gob.Register(map[string]any{})
+
+	gob.Register(orb.Point{})
+	gob.Register(orb.MultiPoint{})
+	gob.Register(orb.LineString{})
+	gob.Register(orb.MultiLineString{})
+	gob.Register(orb.Ring{})
+	gob.Register(orb.Polygon{})
+	gob.Register(orb.MultiPolygon{})
+	gob.Register(orb.Collection{})
+	gob.Register(orb.Bound{})
+}
+
+// TileSpatialDatabase implements the `whosonfirst/go-whosonfirst-spatial/database.SpatialDatabase` interface for
+// the features in an individual tile. Unlike other implementations it operates directly on decoded `orb` geometries
+// rather than GeoJSON-encoded features so there is no need to marshal each feature to JSON and then parse it again
+// in order to index it. Standard Places Responses (SPR) are derived lazily, and only once, for features whose
+// geometries contain the point being queried.
+type TileSpatialDatabase struct {
+	database.SpatialDatabase
+	features    []*tileFeature
+	decode_func tileFeatureDecodeFunc
+	mu          *sync.RWMutex
+}
+
+// tileFeatureDecodeFunc is a function used to expand MVT-encoded properties in a GeoJSON-encoded feature.
+type tileFeatureDecodeFunc func(context.Context, []byte) ([]byte, error)
+
+// tileFeature is a prepared (indexed) representation of a feature in a tile.
+type tileFeature struct {
+	id       string
+	feature  *geojson.Feature
+	bound    orb.Bound
+	polygons []*tilePolygon
+	body     []byte
+	spr      spr.StandardPlacesResult
+	spr_err  error
+	spr_once *sync.Once
+}
+
+// tilePolygon is a polygon whose bounding box, and the bounding boxes of its rings, have been precomputed.
+type tilePolygon struct {
+	bound       orb.Bound
+	rings       []orb.Ring
+	ring_bounds []orb.Bound
+}
+
+type TileResults struct {
+	spr.StandardPlacesResults `json:",omitempty"`
+	Places                    []spr.StandardPlacesResult `json:"places"`
+}
+
+func (r *TileResults) Results() []spr.StandardPlacesResult {
+	return r.Places
+}
+
+// NewTileSpatialDatabase returns a new `TileSpatialDatabase` instance configured by 'uri' which is expected to take
+// the form of:
+//
+//	native://
+func NewTileSpatialDatabase(ctx context.Context, uri string) (database.SpatialDatabase, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse URI, %w", err)
+	}
+
+	if u.Scheme != NATIVE_TILE_DATABASE_SCHEME {
+		return nil, fmt.Errorf("Invalid scheme, %s", u.Scheme)
+	}
+
+	return newTileSpatialDatabase(), nil
+}
+
+func newTileSpatialDatabase() *TileSpatialDatabase {
+
+	db := &TileSpatialDatabase{
+		features: make([]*tileFeature, 0),
+		mu:       new(sync.RWMutex),
+	}
+
+	return db
+}
+
+// tileDatabaseEncoding is the gob-encoded representation of the features in a `TileSpatialDatabase` instance.
+type tileDatabaseEncoding struct {
+	Version  int
+	Features []*tileDatabaseEncodingFeature
+}
+
+// tileDatabaseEncodingFeature is the gob-encoded representation of a single feature in a `TileSpatialDatabase`
+// instance. Geometries are encoded as-is, rather than as GeoJSON, so that coordinates are not truncated.
+type tileDatabaseEncodingFeature struct {
+	ID         any
+	Properties map[string]any
+	Geometry   orb.Geometry
+}
+
+// encode writes the features in the database to 'wr'. Only the features themselves are written, the bounding boxes
+// used to index them are derived again when they are decoded. Empty databases are still written so that they can be
+// told apart from databases that have not been encoded.
+func (db *TileSpatialDatabase) encode(wr io.Writer) error {
+
+	candidates := db.candidates()
+
+	enc := &tileDatabaseEncoding{
+		Version:  tile_database_encoding_version,
+		Features: make([]*tileDatabaseEncodingFeature, len(candidates)),
+	}
+
+	for i, tf := range candidates {
+
+		enc.Features[i] = &tileDatabaseEncodingFeature{
+			ID:         tf.feature.ID,
+			Properties: tf.feature.Properties,
+			Geometry:   tf.feature.Geometry,
+		}
+	}
+
+	err := gob.NewEncoder(wr).Encode(enc)
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode features, %w", err)
+	}
+
+	return nil
+}
+
+// decodeTileFeatures returns the features, written by the `TileSpatialDatabase.encode` method, read from 'r'.
+func decodeTileFeatures(r io.Reader) ([]*geojson.Feature, error) {
+
+	var enc tileDatabaseEncoding
+
+	err := gob.NewDecoder(r).Decode(&enc)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode features, %w", err)
+	}
+
+	if enc.Version != tile_database_encoding_version {
+		return nil, fmt.Errorf("Unsupported encoding version %d", enc.Version)
+	}
+
+	features := make([]*geojson.Feature, len(enc.Features))
+
+	for i, enc_f := range enc.Features {
+
+		f := geojson.NewFeature(enc_f.Geometry)
+		f.ID = enc_f.ID
+
+		if enc_f.Properties != nil {
+			f.Properties = enc_f.Properties
+		}
+
+		features[i] = f
+	}
+
+	return features, nil
+}
+
+// indexGeoJSONFeature adds 'f' to the database. If 'body' is not nil it is assumed to be the (decoded) GeoJSON
+// encoding of 'f' and is used to derive the SPR for the feature. Otherwise 'f' is marshaled, and decoded, on demand.
+func (db *TileSpatialDatabase) indexGeoJSONFeature(ctx context.Context, f *geojson.Feature, body []byte) error {
+
+	tf, err := newTileFeature(f, body)
+
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.features = append(db.features, tf)
+	return nil
+}
+
+// candidates returns a copy of the list of features in the database.
+func (db *TileSpatialDatabase) candidates() []*tileFeature {
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	candidates := make([]*tileFeature, len(db.features))
+	copy(candidates, db.features)
+
+	return candidates
+}
+
+func newTileFeature(f *geojson.Feature, body []byte) (*tileFeature, error) {
+
+	if f.Geometry == nil {
+		return nil, fmt.Errorf("Feature is missing geometry")
+	}
+
+	id, err := tileFeatureId(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	polygons := make([]*tilePolygon, 0)
+
+	switch g := f.Geometry.(type) {
+	case orb.Polygon:
+		polygons = append(polygons, newTilePolygon(g))
+	case orb.MultiPolygon:
+
+		for _, poly := range g {
+			polygons = append(polygons, newTilePolygon(poly))
+		}
+
+	default:
+		// pass, not a polygon so it will never contain a point
+	}
+
+	tf := &tileFeature{
+		id:       id,
+		feature:  f,
+		bound:    f.Geometry.Bound(),
+		polygons: polygons,
+		body:     body,
+		spr_once: new(sync.Once),
+	}
+
+	return tf, nil
+}
+
+func newTilePolygon(poly orb.Polygon) *tilePolygon {
+
+	ring_bounds := make([]orb.Bound, len(poly))
+
+	for i, ring := range poly {
+		ring_bounds[i] = ring.Bound()
+	}
+
+	p := &tilePolygon{
+		rings:       poly,
+		ring_bounds: ring_bounds,
+	}
+
+	if len(ring_bounds) > 0 {
+		p.bound = ring_bounds[0]
+	}
+
+	return p
+}
+
+// contains reports whether 'pt' is contained by any of the polygons in 'tf'.
+func (tf *tileFeature) contains(pt orb.Point) bool {
+
+	if !tf.bound.Contains(pt) {
+		return false
+	}
+
+	for _, p := range tf.polygons {
+
+		if p.contains(pt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contains reports whether 'pt' is contained by the exterior ring, and none of the interior rings, of 'p'.
+func (p *tilePolygon) contains(pt orb.Point) bool {
+
+	if len(p.rings) == 0 {
+		return false
+	}
+
+	if !p.bound.Contains(pt) {
+		return false
+	}
+
+	if !planar.RingContains(p.rings[0], pt) {
+		return false
+	}
+
+	for i := 1; i < len(p.rings); i++ {
+
+		if !p.ring_bounds[i].Contains(pt) {
+			continue
+		}
+
+		if planar.RingContains(p.rings[i], pt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// standardPlacesResult returns the SPR for 'tf', deriving it the first time the method is invoked.
+func (tf *tileFeature) standardPlacesResult(ctx context.Context, decode_func tileFeatureDecodeFunc) (spr.StandardPlacesResult, error) {
+
+	tf.spr_once.Do(func() {
+
+		body := tf.body
+
+		if body == nil {
+
+			enc, err := tf.feature.MarshalJSON()
+
+			if err != nil {
+				tf.spr_err = fmt.Errorf("Failed to marshal feature %s, %w", tf.id, err)
+				return
+			}
+
+			if decode_func != nil {
+
+				enc, err = decode_func(ctx, enc)
+
+				if err != nil {
+					tf.spr_err = fmt.Errorf("Failed to decode feature %s, %w", tf.id, err)
+					return
+				}
+			}
+
+			body = enc
+		}
+
+		s, err := standardPlacesResultFromBody(body)
+
+		if err != nil {
+			tf.spr_err = fmt.Errorf("Failed to derive SPR for feature %s, %w", tf.id, err)
+			return
+		}
+
+		tf.spr = s
+	})
+
+	return tf.spr, tf.spr_err
+}
+
+// standardPlacesResultFromBody returns the SPR for the (decoded) GeoJSON-encoded feature 'body' accounting for
+// alternate geometries.
+func standardPlacesResultFromBody(body []byte) (spr.StandardPlacesResult, error) {
+
+	if alt.IsAlt(body) {
+		return spr.WhosOnFirstAltSPR(body)
+	}
+
+	return spr.WhosOnFirstSPR(body)
+}
+
+// matchesFilters reports whether 's' satisfies all of 'filters'.
+func matchesFilters(s spr.StandardPlacesResult, filters ...spatial.Filter) bool {
+
+	for _, f := range filters {
+
+		err := filter.FilterSPR(f, s)
+
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tileFeatureId returns the unique identifier for 'f' derived from its "wof:id" and "src:alt_label" properties.
+func tileFeatureId(f *geojson.Feature) (string, error) {
+
+	var id int64
+
+	switch v := f.Properties["wof:id"].(type) {
+	case float64:
+		id = int64(v)
+	case int64:
+		id = v
+	case int:
+		id = int64(v)
+	case string:
+
+		i, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to parse wof:id property, %w", err)
+		}
+
+		id = i
+
+	default:
+
+		f_id, ok := f.ID.(float64)
+
+		if !ok {
+			return "", fmt.Errorf("Feature is missing wof:id property")
+		}
+
+		id = int64(f_id)
+	}
+
+	str_id := strconv.FormatInt(id, 10)
+
+	alt_label, ok := f.Properties["src:alt_label"].(string)
+
+	if ok && alt_label != "" {
+		str_id = fmt.Sprintf("%s-alt-%s", str_id, alt_label)
+	}
+
+	return str_id, nil
+}
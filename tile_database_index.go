@@ -0,0 +1,209 @@
+package pmtiles
+
+// Implement the whosonfirst/go-whosonfirst-spatial.SpatialIndex interface.
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"log/slog"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+func (db *TileSpatialDatabase) IndexFeature(ctx context.Context, body []byte) error {
+
+	f, err := geojson.UnmarshalFeature(body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal feature, %w", err)
+	}
+
+	return db.indexGeoJSONFeature(ctx, f, body)
+}
+
+func (db *TileSpatialDatabase) RemoveFeature(ctx context.Context, id string) error {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	features := make([]*tileFeature, 0, len(db.features))
+
+	for _, tf := range db.features {
+
+		if tf.id == id {
+			continue
+		}
+
+		features = append(features, tf)
+	}
+
+	if len(features) == len(db.features) {
+		return spatial.ErrNotFound
+	}
+
+	db.features = features
+	return nil
+}
+
+func (db *TileSpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	results := make([]spr.StandardPlacesResult, 0)
+
+	for r, err := range db.PointInPolygonWithIterator(ctx, coord, filters...) {
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, r)
+	}
+
+	spr_results := &TileResults{
+		Places: results,
+	}
+
+	return spr_results, nil
+}
+
+func (db *TileSpatialDatabase) PointInPolygonWithIterator(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) iter.Seq2[spr.StandardPlacesResult, error] {
+
+	return func(yield func(spr.StandardPlacesResult, error) bool) {
+
+		for _, tf := range db.candidates() {
+
+			select {
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			default:
+				// pass
+			}
+
+			if !tf.contains(*coord) {
+				continue
+			}
+
+			s, ok, err := db.matchFeature(ctx, tf, filters...)
+
+			if err != nil {
+
+				if !yield(nil, err) {
+					return
+				}
+
+				continue
+			}
+
+			if !ok {
+				continue
+			}
+
+			if !yield(s, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (db *TileSpatialDatabase) Intersects(ctx context.Context, geom orb.Geometry, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	results := make([]spr.StandardPlacesResult, 0)
+
+	for r, err := range db.IntersectsWithIterator(ctx, geom, filters...) {
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, r)
+	}
+
+	spr_results := &TileResults{
+		Places: results,
+	}
+
+	return spr_results, nil
+}
+
+func (db *TileSpatialDatabase) IntersectsWithIterator(ctx context.Context, geom orb.Geometry, filters ...spatial.Filter) iter.Seq2[spr.StandardPlacesResult, error] {
+
+	return func(yield func(spr.StandardPlacesResult, error) bool) {
+
+		geom_bound := geom.Bound()
+
+		for _, tf := range db.candidates() {
+
+			select {
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			default:
+				// pass
+			}
+
+			if len(tf.polygons) == 0 || !tf.bound.Intersects(geom_bound) {
+				continue
+			}
+
+			intersects, err := geo.Intersects(tf.feature.Geometry, geom)
+
+			if err != nil {
+				slog.Error("Failed to determine if feature intersects", "id", tf.id, "error", err)
+				continue
+			}
+
+			if !intersects {
+				continue
+			}
+
+			s, ok, err := db.matchFeature(ctx, tf, filters...)
+
+			if err != nil {
+
+				if !yield(nil, err) {
+					return
+				}
+
+				continue
+			}
+
+			if !ok {
+				continue
+			}
+
+			if !yield(s, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (db *TileSpatialDatabase) Disconnect(ctx context.Context) error {
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.features = make([]*tileFeature, 0)
+	return nil
+}
+
+// matchFeature derives the SPR for 'tf' and reports whether it satisfies all of 'filters'.
+func (db *TileSpatialDatabase) matchFeature(ctx context.Context, tf *tileFeature, filters ...spatial.Filter) (spr.StandardPlacesResult, bool, error) {
+
+	s, err := tf.standardPlacesResult(ctx, db.decode_func)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !matchesFilters(s, filters...) {
+		return nil, false, nil
+	}
+
+	return s, true, nil
+}
@@ -0,0 +1,95 @@
+package pmtiles
+
+// Implement the whosonfirst/go-reader/v2.Reader interface.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+)
+
+func (db *TileSpatialDatabase) Read(ctx context.Context, path string) (io.ReadSeekCloser, error) {
+
+	tf, err := db.featureForPath(ctx, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	body := tf.body
+
+	if body == nil {
+
+		enc, err := tf.feature.MarshalJSON()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal feature for %s, %w", path, err)
+		}
+
+		if db.decode_func != nil {
+
+			enc, err = db.decode_func(ctx, enc)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to decode feature for %s, %w", path, err)
+			}
+		}
+
+		body = enc
+	}
+
+	r := bytes.NewReader(body)
+	return ioutil.NewReadSeekCloser(r)
+}
+
+func (db *TileSpatialDatabase) Exists(ctx context.Context, path string) (bool, error) {
+
+	_, err := db.featureForPath(ctx, path)
+
+	if err != nil {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (db *TileSpatialDatabase) ReaderURI(ctx context.Context, path string) string {
+	return path
+}
+
+func (db *TileSpatialDatabase) featureForPath(ctx context.Context, path string) (*tileFeature, error) {
+
+	id, uri_args, err := uri.ParseURI(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s, %w", path, err)
+	}
+
+	str_id := strconv.FormatInt(id, 10)
+
+	if uri_args.IsAlternate {
+
+		alt_label, err := uri_args.AltGeom.String()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive alt label for %s, %w", path, err)
+		}
+
+		str_id = fmt.Sprintf("%s-alt-%s", str_id, alt_label)
+	}
+
+	for _, tf := range db.candidates() {
+
+		if tf.id == str_id {
+			return tf, nil
+		}
+	}
+
+	return nil, spatial.ErrNotFound
+}
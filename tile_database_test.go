@@ -0,0 +1,168 @@
+package pmtiles
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestTileSpatialDatabase(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, "native://")
+
+	if err != nil {
+		t.Fatalf("Failed to create tile spatial database, %v", err)
+	}
+
+	defer db.Disconnect(ctx)
+
+	for _, path := range []string{"fixtures/85847559.geojson", "fixtures/1108830809.geojson"} {
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", path, err)
+		}
+
+		err = db.IndexFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to index %s, %v", path, err)
+		}
+	}
+
+	tests := map[string]orb.Point{
+		"1108830809": orb.Point{-122.414647, 37.759415},
+		"85847559":   orb.Point{-122.489208, 37.785856},
+		"":           orb.Point{-122.384292, 37.621131},
+	}
+
+	for expected, pt := range tests {
+
+		rsp, err := db.PointInPolygon(ctx, &pt)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query for %v, %v", pt, err)
+		}
+
+		results := rsp.Results()
+
+		if expected == "" {
+
+			if len(results) != 0 {
+				t.Fatalf("Expected no results for %v, got %d", pt, len(results))
+			}
+
+			continue
+		}
+
+		if len(results) != 1 {
+			t.Fatalf("Expected a single result for %v, got %d", pt, len(results))
+		}
+
+		if results[0].Id() != expected {
+			t.Fatalf("Unexpected result for %v, %s (expected %s)", pt, results[0].Id(), expected)
+		}
+	}
+}
+
+func TestTileSpatialDatabaseEncoding(t *testing.T) {
+
+	ctx := context.Background()
+
+	db := newTileSpatialDatabase()
+
+	for _, path := range []string{"fixtures/85847559.geojson", "fixtures/1108830809.geojson"} {
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", path, err)
+		}
+
+		err = db.IndexFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to index %s, %v", path, err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	err := db.encode(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to encode tile spatial database, %v", err)
+	}
+
+	features, err := decodeTileFeatures(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to decode tile spatial database, %v", err)
+	}
+
+	candidates := db.candidates()
+
+	if len(features) != len(candidates) {
+		t.Fatalf("Unexpected number of features %d, expected %d", len(features), len(candidates))
+	}
+
+	decoded_db := newTileSpatialDatabase()
+
+	for i, f := range features {
+
+		if !orb.Equal(f.Geometry, candidates[i].feature.Geometry) {
+			t.Fatalf("Unexpected geometry for feature at offset %d", i)
+		}
+
+		err := decoded_db.indexGeoJSONFeature(ctx, f, nil)
+
+		if err != nil {
+			t.Fatalf("Failed to index decoded feature at offset %d, %v", i, err)
+		}
+	}
+
+	pt := orb.Point{-122.414647, 37.759415}
+
+	rsp, err := decoded_db.PointInPolygon(ctx, &pt)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query for %v, %v", pt, err)
+	}
+
+	results := rsp.Results()
+
+	if len(results) != 1 || results[0].Id() != "1108830809" {
+		t.Fatalf("Unexpected results for %v, %v", pt, results)
+	}
+
+	// Empty databases are still encoded so that they can be told apart from missing ones
+
+	buf.Reset()
+
+	err = newTileSpatialDatabase().encode(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to encode empty tile spatial database, %v", err)
+	}
+
+	if buf.Len() == 0 {
+		t.Fatalf("Expected empty tile spatial database to be encoded")
+	}
+
+	features, err = decodeTileFeatures(&buf)
+
+	if err != nil {
+		t.Fatalf("Failed to decode empty tile spatial database, %v", err)
+	}
+
+	if len(features) != 0 {
+		t.Fatalf("Unexpected number of features %d, expected 0", len(features))
+	}
+}
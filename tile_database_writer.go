@@ -0,0 +1,31 @@
+package pmtiles
+
+// Implement the whosonfirst/go-writer/v3.Writer interface.
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+)
+
+func (db *TileSpatialDatabase) Write(ctx context.Context, key string, fh io.ReadSeeker) (int64, error) {
+	return 0, spatial.ErrNotImplemented
+}
+
+func (db *TileSpatialDatabase) WriterURI(ctx context.Context, str_uri string) string {
+	return str_uri
+}
+
+func (db *TileSpatialDatabase) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (db *TileSpatialDatabase) Close(ctx context.Context) error {
+	return nil
+}
+
+func (db *TileSpatialDatabase) SetLogger(ctx context.Context, logger *log.Logger) error {
+	return nil
+}